@@ -4,12 +4,15 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -29,6 +32,12 @@ type SubsonicResponse struct {
 		AlbumList struct {
 			Albums []Album `json:"album"`
 		} `json:"albumList"`
+		AlbumList2 struct {
+			Albums []Album `json:"album"`
+		} `json:"albumList2"`
+		Artists struct {
+			Index []ArtistIndex `json:"index"`
+		} `json:"artists"`
 		Artist struct {
 			Albums []Album `json:"album"`
 		} `json:"artist"`
@@ -37,10 +46,21 @@ type SubsonicResponse struct {
 			Album  []Album  `json:"album"`
 			Song   []Song   `json:"song"`
 		} `json:"starred2"`
-		PlayQueue PlayQueue `json:"playQueue"`
+		PlayQueue  PlayQueue  `json:"playQueue"`
+		ScanStatus ScanStatus `json:"scanStatus"`
+		Error      struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
 	} `json:"subsonic-response"`
 }
 
+// ErrNotFound is wrapped by errors for data the server does not have
+// (Subsonic error 70). Unlike other errors, retrying will not help.
+var ErrNotFound = errors.New("not found on server")
+
+const subsonicErrNotFound = 70
+
 type PlayQueue struct {
 	Current string `json:"current"`
 	Entries []Song `json:"entry"`
@@ -52,9 +72,22 @@ type SearchResult3 struct {
 	Songs   []Song   `json:"song"`
 }
 
+type ArtistIndex struct {
+	Name    string   `json:"name"`
+	Artists []Artist `json:"artist"`
+}
+
+type ScanStatus struct {
+	Scanning bool   `json:"scanning"`
+	Count    int    `json:"count"`
+	LastScan string `json:"lastScan"`
+}
+
 type Artist struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	AlbumCount int    `json:"albumCount"`
+	Starred    string `json:"starred"`
 }
 
 type Album struct {
@@ -64,13 +97,55 @@ type Album struct {
 }
 
 type Song struct {
-	ID       string `json:"id"`
-	Title    string `json:"title"`
-	Artist   string `json:"artist"`
-	ArtistID string `json:"artistId"`
-	Album    string `json:"album"`
-	AlbumID  string `json:"albumId"`
-	Duration int    `json:"duration"`
+	ID                 string      `json:"id"`
+	Title              string      `json:"title"`
+	Artist             string      `json:"artist"`
+	ArtistID           string      `json:"artistId"`
+	Album              string      `json:"album"`
+	AlbumID            string      `json:"albumId"`
+	AlbumArtists       []Artist    `json:"albumArtists"`
+	DisplayAlbumArtist string      `json:"displayAlbumArtist"`
+	Duration           int         `json:"duration"`
+	Track              int         `json:"track"`
+	DiscNumber         int         `json:"discNumber"`
+	Year               int         `json:"year"`
+	Genre              string      `json:"genre"`
+	BitRate            int         `json:"bitRate"`
+	Suffix             string      `json:"suffix"`
+	ContentType        string      `json:"contentType"`
+	Size               int64       `json:"size"`
+	Path               string      `json:"path"`
+	PlayCount          int64       `json:"playCount"`
+	Played             string      `json:"played"`
+	Created            string      `json:"created"`
+	BPM                int         `json:"bpm"`
+	Type               string      `json:"type"` // music, podcast or audiobook
+	ReplayGain         *ReplayGain `json:"replayGain,omitempty"`
+}
+
+// AlbumArtist names the artists of the song's album from the OpenSubsonic
+// albumArtists list, falling back to its display string. Plain Subsonic
+// servers send neither.
+func (s Song) AlbumArtist() string {
+	names := []string{}
+	for _, artist := range s.AlbumArtists {
+		names = append(names, artist.Name)
+	}
+	if len(names) > 0 {
+		return strings.Join(names, ", ")
+	}
+
+	return s.DisplayAlbumArtist
+}
+
+// ReplayGain is the OpenSubsonic extension, gains in dB
+type ReplayGain struct {
+	TrackGain    float64 `json:"trackGain"`
+	AlbumGain    float64 `json:"albumGain"`
+	TrackPeak    float64 `json:"trackPeak"`
+	AlbumPeak    float64 `json:"albumPeak"`
+	BaseGain     float64 `json:"baseGain"`
+	FallbackGain float64 `json:"fallbackGain"`
 }
 
 type Playlist struct {
@@ -87,35 +162,70 @@ func generateSalt() string {
 	return string(b)
 }
 
-func subsonicGET(endpoint string, params map[string]string) (*SubsonicResponse, error) {
-	baseUrl := AppConfig.URL + "/rest" + endpoint
+func authValues() url.Values {
+	profile := CurrentProfile()
 
 	salt := generateSalt()
-	hash := md5.Sum([]byte(AppConfig.Password + salt))
+	hash := md5.Sum([]byte(profile.Password + salt))
 	token := hex.EncodeToString(hash[:])
 
 	v := url.Values{}
-	v.Set("u", AppConfig.Username)
+	v.Set("u", profile.Username)
 	v.Set("t", token)
 	v.Set("s", salt)
 	v.Set("v", "1.16.1")
 	v.Set("c", "SubTUI")
 	v.Set("f", "json")
 
+	return v
+}
+
+func subsonicFetch(endpoint string, params map[string]string) ([]byte, error) {
+	baseUrl := CurrentProfile().URL + "/rest" + endpoint
+
+	v := authValues()
 	for key, value := range params {
 		v.Set(key, value)
 	}
 
 	fullUrl := baseUrl + "?" + v.Encode()
 
-	resp, err := http.Get(fullUrl)
+	start := time.Now()
+	resp, err := httpGet(fullUrl)
 	if err != nil {
+		log.Printf("api: %s: %v", endpoint, err)
 		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	log.Printf("api: %s %v: %s in %v", endpoint, params, resp.Status, time.Since(start))
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Some servers send errors like a failed login with an HTTP error status.
+	// Their Subsonic error says more than the status, anything else, like a
+	// proxy's error page, does not count as an answer.
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var result SubsonicResponse
+		if err := json.Unmarshal(body, &result); err != nil || result.Response.Status == "" {
+			return nil, fmt.Errorf("server answered %s", resp.Status)
+		}
+	}
+
+	return body, nil
+}
+
+func subsonicGET(endpoint string, params map[string]string) (*SubsonicResponse, error) {
+	body, err := subsonicFetch(endpoint, params)
+	if err != nil {
+		return nil, err
+	}
+
 	var result SubsonicResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := json.Unmarshal(body, &result); err != nil {
 		return nil, err
 	}
 
@@ -129,6 +239,9 @@ func SubsonicPing() error {
 	}
 
 	if data.Response.Status != "ok" {
+		if data.Response.Error.Message != "" {
+			return fmt.Errorf("authentication failed: %s", data.Response.Error.Message)
+		}
 		return fmt.Errorf("authentication failed: server returned status %s", data.Response.Status)
 	}
 
@@ -197,7 +310,7 @@ func SubsonicGetPlaylistSongs(id string) ([]Song, error) {
 		"id": id,
 	}
 
-	data, err := subsonicGET("/getPlaylist", params)
+	data, err := subsonicCachedGET("/getPlaylist", params)
 	if err != nil {
 		return nil, err
 	}
@@ -217,11 +330,21 @@ func SubsonicGetPlaylists() ([]Playlist, error) {
 }
 
 func SubsonicGetAlbum(id string) ([]Song, error) {
+	return getAlbum(subsonicCachedGET, id)
+}
+
+// SubsonicGetAlbumUncached bypasses the response cache, for bulk reads that
+// need current data and should not fill the cache.
+func SubsonicGetAlbumUncached(id string) ([]Song, error) {
+	return getAlbum(subsonicGET, id)
+}
+
+func getAlbum(get func(string, map[string]string) (*SubsonicResponse, error), id string) ([]Song, error) {
 	params := map[string]string{
 		"id": id,
 	}
 
-	data, err := subsonicGET("/getAlbum", params)
+	data, err := get("/getAlbum", params)
 	if err != nil {
 		return nil, err
 	}
@@ -229,13 +352,15 @@ func SubsonicGetAlbum(id string) ([]Song, error) {
 	return data.Response.Album.Songs, nil
 }
 
-func SubsonicGetAlbumList(searchType string) ([]Album, error) {
-	params := map[string]string{
+func albumListParams(searchType string) map[string]string {
+	return map[string]string{
 		"type": searchType,
 		"size": "100",
 	}
+}
 
-	data, err := subsonicGET("/getAlbumList", params)
+func SubsonicGetAlbumList(searchType string) ([]Album, error) {
+	data, err := subsonicCachedGET("/getAlbumList", albumListParams(searchType))
 	if err != nil {
 		return nil, err
 	}
@@ -243,12 +368,61 @@ func SubsonicGetAlbumList(searchType string) ([]Album, error) {
 	return data.Response.AlbumList.Albums, nil
 }
 
+func SubsonicGetAlbumList2(searchType string, size int, offset int) ([]Album, error) {
+	params := map[string]string{
+		"type":   searchType,
+		"size":   strconv.Itoa(size),
+		"offset": strconv.Itoa(offset),
+	}
+
+	data, err := subsonicGET("/getAlbumList2", params)
+	if err != nil {
+		return nil, err
+	}
+
+	return data.Response.AlbumList2.Albums, nil
+}
+
+func SubsonicGetArtists() ([]ArtistIndex, error) {
+	return getArtists(subsonicCachedGET)
+}
+
+func SubsonicGetArtistsUncached() ([]ArtistIndex, error) {
+	return getArtists(subsonicGET)
+}
+
+func getArtists(get func(string, map[string]string) (*SubsonicResponse, error)) ([]ArtistIndex, error) {
+	data, err := get("/getArtists", map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+
+	return data.Response.Artists.Index, nil
+}
+
+func SubsonicGetScanStatus() (*ScanStatus, error) {
+	data, err := subsonicGET("/getScanStatus", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Response.ScanStatus, nil
+}
+
 func SubsonicGetArtist(id string) ([]Album, error) {
+	return getArtist(subsonicCachedGET, id)
+}
+
+func SubsonicGetArtistUncached(id string) ([]Album, error) {
+	return getArtist(subsonicGET, id)
+}
+
+func getArtist(get func(string, map[string]string) (*SubsonicResponse, error), id string) ([]Album, error) {
 	params := map[string]string{
 		"id": id,
 	}
 
-	data, err := subsonicGET("/getArtist", params)
+	data, err := get("/getArtist", params)
 	if err != nil {
 		return nil, err
 	}
@@ -261,7 +435,9 @@ func SubsonicStar(id string) {
 		"id": id,
 	}
 
-	_, _ = subsonicGET("/star", params)
+	if data, err := subsonicGET("/star", params); err == nil && data.Response.Status == "ok" {
+		evictStarred()
+	}
 }
 
 func SubsonicUnstar(id string) {
@@ -269,7 +445,18 @@ func SubsonicUnstar(id string) {
 		"id": id,
 	}
 
-	_, _ = subsonicGET("/unstar", params)
+	if data, err := subsonicGET("/unstar", params); err == nil && data.Response.Status == "ok" {
+		evictStarred()
+	}
+}
+
+// evictStarred drops the cached responses that show what is starred. Which
+// albums and playlists contain a song is not known here, so all of them go.
+func evictStarred() {
+	evictCache("/getAlbumList", albumListParams("starred"))
+	evictCache("/getArtists", nil)
+	evictCache("/getAlbum", nil)
+	evictCache("/getPlaylist", nil)
 }
 
 func SubsonicGetStarred() (*SearchResult3, error) {
@@ -286,87 +473,106 @@ func SubsonicGetStarred() (*SearchResult3, error) {
 }
 
 func SubsonicStream(id string) string {
-	baseUrl := AppConfig.URL + "/rest/stream"
+	baseUrl := CurrentProfile().URL + "/rest/stream"
 
-	salt := generateSalt()
-	hash := md5.Sum([]byte(AppConfig.Password + salt))
-	token := hex.EncodeToString(hash[:])
+	_, transcode := CurrentTranscodeProfile()
 
-	v := url.Values{}
+	v := authValues()
 	v.Set("id", id)
-	v.Set("maxBitRate", "0")
-	v.Set("u", AppConfig.Username)
-	v.Set("t", token)
-	v.Set("s", salt)
-	v.Set("v", "1.16.1")
-	v.Set("c", "SubTUI")
-	v.Set("f", "json")
+	v.Set("maxBitRate", strconv.Itoa(transcode.MaxBitRate))
+	if transcode.Format != "" {
+		v.Set("format", transcode.Format)
+	}
+	if AppConfig.Stream.EstimateContentLength {
+		v.Set("estimateContentLength", "true")
+	}
 
 	fullUrl := baseUrl + "?" + v.Encode()
 
 	return fullUrl
 }
 
-func SubsonicScrobble(id string, submission bool) {
-	time := strconv.FormatInt(time.Now().UTC().UnixMilli(), 10)
+// SubsonicDownload requests the original file. The caller has to close the
+// response body.
+func SubsonicDownload(id string) (*http.Response, error) {
+	baseUrl := CurrentProfile().URL + "/rest/download"
+
+	v := authValues()
+	v.Set("id", id)
+
+	resp, err := httpGet(baseUrl + "?" + v.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	// Errors come back as a JSON envelope instead of the file
+	if resp.StatusCode != http.StatusOK || strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("server refused download: %s", resp.Status)
+	}
+
+	return resp, nil
+}
 
+// SubsonicScrobble reports a song as playing now, or with submission as
+// played at the given time.
+func SubsonicScrobble(id string, submission bool, at time.Time) error {
 	params := map[string]string{
 		"id":         id,
-		"time":       time,
+		"time":       strconv.FormatInt(at.UnixMilli(), 10),
 		"submission": strconv.FormatBool(submission),
 	}
 
-	_, _ = subsonicGET("/scrobble", params)
+	data, err := subsonicGET("/scrobble", params)
+	if err != nil {
+		return err
+	}
+
+	if data.Response.Status != "ok" {
+		if data.Response.Error.Code == subsonicErrNotFound {
+			return fmt.Errorf("%w: %s", ErrNotFound, data.Response.Error.Message)
+		}
+		return fmt.Errorf("server error %d: %s", data.Response.Error.Code, data.Response.Error.Message)
+	}
+
+	return nil
 }
 
 func SubsonicCoverArt(id string) ([]byte, error) {
-	baseUrl := AppConfig.URL + "/rest/getCoverArt"
-
-	salt := generateSalt()
-	hash := md5.Sum([]byte(AppConfig.Password + salt))
-	token := hex.EncodeToString(hash[:])
+	params := map[string]string{
+		"id":   id,
+		"size": "50",
+	}
 
-	v := url.Values{}
-	v.Set("id", id)
-	v.Set("size", "50")
-	v.Set("u", AppConfig.Username)
-	v.Set("t", token)
-	v.Set("s", salt)
-	v.Set("v", "1.16.1")
-	v.Set("c", "SubTUI")
-	v.Set("f", "json")
+	key := cacheKey("/getCoverArt", params)
+	if data, age, ok := readCache(key); ok {
+		if age > cacheTTLs["/getCoverArt"] {
+			go revalidate(key, func() { _, _ = fetchCoverArt(params, key) })
+		}
+		return data, nil
+	}
 
-	url := baseUrl + "?" + v.Encode()
+	return fetchCoverArt(params, key)
+}
 
-	resp, err := http.Get(url)
+func fetchCoverArt(params map[string]string, key string) ([]byte, error) {
+	data, err := subsonicFetch("/getCoverArt", params)
 	if err != nil {
 		return nil, err
 	}
-	defer func() { _ = resp.Body.Close() }()
 
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	// Errors come back as a JSON envelope instead of image bytes
+	if len(data) > 0 && data[0] != '{' {
+		writeCache(key, data)
 	}
 
 	return data, nil
 }
 
 func SubsonicSaveQueue(ids []string, currentID string) {
-	baseUrl := AppConfig.URL + "/rest/savePlayQueue"
-
-	salt := generateSalt()
-	hash := md5.Sum([]byte(AppConfig.Password + salt))
-	token := hex.EncodeToString(hash[:])
-
-	v := url.Values{}
-	v.Set("u", AppConfig.Username)
-	v.Set("t", token)
-	v.Set("s", salt)
-	v.Set("v", "1.16.1")
-	v.Set("c", "SubTUI")
-	v.Set("f", "json")
+	baseUrl := CurrentProfile().URL + "/rest/savePlayQueue"
 
+	v := authValues()
 	v.Set("current", currentID)
 	for _, id := range ids {
 		v.Add("id", id)
@@ -374,7 +580,10 @@ func SubsonicSaveQueue(ids []string, currentID string) {
 
 	url := baseUrl + "?" + v.Encode()
 
-	resp, _ := http.Get(url)
+	resp, err := httpGet(url)
+	if err != nil {
+		return
+	}
 	defer func() { _ = resp.Body.Close() }()
 }
 
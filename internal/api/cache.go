@@ -0,0 +1,214 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a cached response counts as fresh. Stale entries are still
+// served, but trigger a refresh in the background.
+var cacheTTLs = map[string]time.Duration{
+	"/getAlbum":     24 * time.Hour,
+	"/getArtist":    24 * time.Hour,
+	"/getArtists":   time.Hour,
+	"/getPlaylist":  10 * time.Minute,
+	"/getAlbumList": 10 * time.Minute,
+	"/getCoverArt":  30 * 24 * time.Hour,
+}
+
+var revalidating sync.Map
+
+// A background refresh changed a cached response
+var cacheUpdates = make(chan struct{}, 1)
+
+func cacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "subtui", "responses"), nil
+}
+
+func cacheKey(endpoint string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	profile := CurrentProfile()
+
+	h := sha256.New()
+	h.Write([]byte(profile.URL + "\x00" + profile.Username + "\x00" + endpoint))
+	for _, key := range keys {
+		h.Write([]byte("\x00" + key + "=" + params[key]))
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	// One directory per endpoint, so all of its entries can be evicted
+	return filepath.Join(strings.TrimPrefix(endpoint, "/"), sum[:2], sum)
+}
+
+func cacheable(endpoint string, params map[string]string) bool {
+	if _, ok := cacheTTLs[endpoint]; !ok {
+		return false
+	}
+
+	// A cached random list would not be random anymore
+	return !(endpoint == "/getAlbumList" && params["type"] == "random")
+}
+
+func readCache(key string) ([]byte, time.Duration, bool) {
+	dir, err := cacheDir()
+	if err != nil {
+		return nil, 0, false
+	}
+
+	path := filepath.Join(dir, key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	return data, time.Since(info.ModTime()), true
+}
+
+func writeCache(key string, data []byte) {
+	dir, err := cacheDir()
+	if err != nil {
+		return
+	}
+
+	path := filepath.Join(dir, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+
+	// Write to a temp file first so readers never see a partial entry
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(key)+".*.tmp")
+	if err != nil {
+		return
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+}
+
+// fetchAndCache requests the endpoint and stores the body if the server
+// answered with a successful response.
+func fetchAndCache(endpoint string, params map[string]string, key string) (*SubsonicResponse, error) {
+	body, err := subsonicFetch(endpoint, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var result SubsonicResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	if result.Response.Status == "ok" {
+		writeCache(key, body)
+	}
+
+	return &result, nil
+}
+
+// evictCache drops cached responses made stale by a change on the server:
+// the entry for params, or with nil params every entry of the endpoint, of
+// all profiles.
+func evictCache(endpoint string, params map[string]string) {
+	dir, err := cacheDir()
+	if err != nil {
+		return
+	}
+
+	if params == nil {
+		_ = os.RemoveAll(filepath.Join(dir, strings.TrimPrefix(endpoint, "/")))
+		return
+	}
+
+	_ = os.Remove(filepath.Join(dir, cacheKey(endpoint, params)))
+}
+
+// CacheUpdates receives a value when a background refresh changed a cached
+// response, so the view showing it can be loaded again.
+func CacheUpdates() <-chan struct{} {
+	return cacheUpdates
+}
+
+// revalidate runs refresh unless a refresh of the same entry is running.
+func revalidate(key string, refresh func()) {
+	if _, running := revalidating.LoadOrStore(key, true); running {
+		return
+	}
+	defer revalidating.Delete(key)
+
+	refresh()
+}
+
+// subsonicCachedGET behaves like subsonicGET but serves read-only endpoints
+// from the disk cache when possible (stale-while-revalidate).
+func subsonicCachedGET(endpoint string, params map[string]string) (*SubsonicResponse, error) {
+	if !cacheable(endpoint, params) {
+		return subsonicGET(endpoint, params)
+	}
+
+	key := cacheKey(endpoint, params)
+	if body, age, ok := readCache(key); ok {
+		var result SubsonicResponse
+		if err := json.Unmarshal(body, &result); err == nil {
+			if age > cacheTTLs[endpoint] {
+				go revalidate(key, func() {
+					_, _ = fetchAndCache(endpoint, params, key)
+
+					if fresh, _, ok := readCache(key); ok && !bytes.Equal(fresh, body) {
+						select {
+						case cacheUpdates <- struct{}{}:
+						default:
+						}
+					}
+				})
+			}
+			return &result, nil
+		}
+	}
+
+	return fetchAndCache(endpoint, params, key)
+}
+
+func PurgeCache() error {
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(dir)
+}
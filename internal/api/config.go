@@ -1,31 +1,167 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
-type Config struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+type Profile struct {
+	Name     string `yaml:"name"`
 	URL      string `yaml:"URL"`
+	Username string `yaml:"username"`
+
+	// Only written with the plain secrets backend
+	Password          string `yaml:"password,omitempty"`
+	PasswordCommand   string `yaml:"password_command,omitempty"`
+	EncryptedPassword string `yaml:"encrypted_password,omitempty"`
+
+	Network NetworkConfig `yaml:"network,omitempty"`
+}
+
+type NetworkConfig struct {
+	CAFile             string            `yaml:"ca_file,omitempty"`
+	InsecureSkipVerify bool              `yaml:"insecure_skip_verify,omitempty"`
+	ClientCert         string            `yaml:"client_cert,omitempty"`
+	ClientKey          string            `yaml:"client_key,omitempty"`
+	Proxy              string            `yaml:"proxy,omitempty"` // http://, https:// or socks5://
+	Headers            map[string]string `yaml:"headers,omitempty"`
+}
+
+type SecretsConfig struct {
+	Backend string `yaml:"backend"` // auto, keyring, encrypted or plain
+}
+
+type TranscodeProfile struct {
+	MaxBitRate int    `yaml:"max_bitrate"`
+	Format     string `yaml:"format"`
+}
+
+type StreamConfig struct {
+	Profile               string                      `yaml:"profile"`
+	EstimateContentLength bool                        `yaml:"estimate_content_length"`
+	Profiles              map[string]TranscodeProfile `yaml:"profiles"`
+}
+
+type DownloadConfig struct {
+	Dir       string `yaml:"dir"`
+	MaxSizeMB int64  `yaml:"max_size_mb"`
+}
+
+type ReplayGainConfig struct {
+	Mode            string  `yaml:"mode"`
+	PreAmp          float64 `yaml:"preamp"`
+	PreventClipping bool    `yaml:"prevent_clipping"`
+}
+
+type PlayerConfig struct {
+	Volume          *int               `yaml:"volume,omitempty"` // Restored on startup, mpv's default when unset
+	VolumeMax       int                `yaml:"volume_max"`       // Above 100 boosts with software volume
+	Speeds          map[string]float64 `yaml:"speeds"`           // Per content kind: music, podcast, audiobook
+	PitchCorrection *bool              `yaml:"pitch_correction,omitempty"`
+	SeekSmall       int                `yaml:"seek_small"`   // Seconds, 10 by default
+	SeekLarge       int                `yaml:"seek_large"`   // Seconds, 60 by default
+	Fade            float64            `yaml:"fade"`         // Seconds to fade out and in between songs, 0 plays gapless
+	AudioDevice     string             `yaml:"audio_device"` // mpv device name, its default when empty
+}
+
+type EqualizerConfig struct {
+	Preset    string               `yaml:"preset"`
+	Gains     []float64            `yaml:"gains"` // dB per band of EqualizerBands
+	Presets   map[string][]float64 `yaml:"presets"`
+	AutoGenre bool                 `yaml:"auto_genre"`
+	Genres    map[string]string    `yaml:"genres"` // Genre to preset name
+}
+
+type Column struct {
+	Name  string `yaml:"name"`
+	Width int    `yaml:"width"` // Percent of the table, 0 shares what is left
+}
+
+type ColumnConfig struct {
+	Search   []Column `yaml:"search"`
+	Album    []Column `yaml:"album"`
+	Playlist []Column `yaml:"playlist"`
+	Queue    []Column `yaml:"queue"`
+}
+
+type Config struct {
+	Profiles       []Profile        `yaml:"profiles"`
+	ActiveProfile  string           `yaml:"active_profile"`
+	LibraryIndex   bool             `yaml:"library_index"`
+	SingleInstance bool             `yaml:"single_instance"` // Later invocations forward their command
+	Stream         StreamConfig     `yaml:"stream"`
+	Downloads      DownloadConfig   `yaml:"downloads"`
+	ReplayGain     ReplayGainConfig `yaml:"replaygain"`
+	Columns        ColumnConfig     `yaml:"columns"`
+	Secrets        SecretsConfig    `yaml:"secrets"`
+	Player         PlayerConfig     `yaml:"player"`
+	Equalizer      EqualizerConfig  `yaml:"equalizer"`
+
+	// Single server configs from before profiles existed
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	URL      string `yaml:"URL,omitempty"`
 }
 
 var AppConfig Config
 
+// ConfigPath overrides the location of config.yaml.
+var ConfigPath string
+
+// Set from flags and environment variables, these take precedence over the
+// active profile without being saved to it.
+var overrides Profile
+
+func configPath() (string, error) {
+	if ConfigPath != "" {
+		return ConfigPath, nil
+	}
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, "subtui", "config.yaml"), nil
+}
+
+// StateDir holds data that must survive, unlike the cache: usually
+// ~/.local/state/subtui.
+func StateDir() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+
+	return filepath.Join(dir, "subtui"), nil
+}
+
 func LoadConfig() error {
-	home, err := os.UserHomeDir()
+	configPath, err := configPath()
 	if err != nil {
 		return err
 	}
 
-	configPath := filepath.Join(home, ".config", "subtui", "config.yaml")
-
 	file, err := os.Open(configPath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
 		return fmt.Errorf("could not open config file: %v", err)
 	}
 	defer func() { _ = file.Close() }()
@@ -35,22 +171,53 @@ func LoadConfig() error {
 		return fmt.Errorf("could not decode config: %v", err)
 	}
 
+	migrateLegacyConfig()
+
+	// Passwords are only unlocked when their profile is used
+	for _, p := range AppConfig.Profiles {
+		if p.Password != "" && p.PasswordCommand == "" {
+			plaintextFound = true
+		}
+	}
+
 	return nil
 }
 
+func migrateLegacyConfig() {
+	if len(AppConfig.Profiles) == 0 && AppConfig.URL != "" {
+		AppConfig.Profiles = []Profile{{
+			Name:     "default",
+			URL:      AppConfig.URL,
+			Username: AppConfig.Username,
+			Password: AppConfig.Password,
+		}}
+		AppConfig.ActiveProfile = "default"
+	}
+
+	AppConfig.URL = ""
+	AppConfig.Username = ""
+	AppConfig.Password = ""
+}
+
 func SaveConfig() error {
-	home, err := os.UserHomeDir()
+	configPath, err := configPath()
 	if err != nil {
 		return err
 	}
 
-	configPath := filepath.Join(home, ".config", "subtui", "config.yaml")
-
 	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
 		return err
 	}
 
-	file, err := os.Create(configPath)
+	profiles, err := storeSecrets()
+	if err != nil {
+		return err
+	}
+
+	cfg := AppConfig
+	cfg.Profiles = profiles
+
+	file, err := os.OpenFile(configPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
@@ -58,5 +225,313 @@ func SaveConfig() error {
 
 	encoder := yaml.NewEncoder(file)
 	encoder.SetIndent(2)
-	return encoder.Encode(&AppConfig)
+	return encoder.Encode(&cfg)
+}
+
+// CurrentProfile returns the server in use, falling back to the first
+// profile if the active one does not exist.
+func CurrentProfile() Profile {
+	profile := Profile{}
+	if i := activeProfileIndex(); i >= 0 {
+		profile = AppConfig.Profiles[i]
+	}
+
+	if overrides.URL != "" {
+		profile.URL = overrides.URL
+	}
+	if overrides.Username != "" {
+		profile.Username = overrides.Username
+	}
+	if overrides.Password != "" {
+		profile.Password = overrides.Password
+	}
+
+	return profile
+}
+
+// activeProfileIndex falls back to the first profile, or -1 without any.
+func activeProfileIndex() int {
+	for i, p := range AppConfig.Profiles {
+		if p.Name == AppConfig.ActiveProfile {
+			return i
+		}
+	}
+
+	if len(AppConfig.Profiles) > 0 {
+		return 0
+	}
+	return -1
+}
+
+// SetOverrides replaces server, username and password of the active profile
+// until another profile is selected. Empty values keep the profile's own.
+func SetOverrides(server string, username string, password string) {
+	overrides = Profile{
+		URL:      strings.TrimRight(server, "/"),
+		Username: username,
+		Password: password,
+	}
+}
+
+// ServerKey identifies the current server and user, for keeping local data
+// of different profiles apart.
+func ServerKey() string {
+	profile := CurrentProfile()
+	sum := sha256.Sum256([]byte(profile.URL + "\x00" + profile.Username))
+
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func SelectProfile(name string) error {
+	for _, p := range AppConfig.Profiles {
+		if p.Name == name {
+			AppConfig.ActiveProfile = name
+			overrides = Profile{}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown profile %q", name)
+}
+
+// AddProfile stores a new profile and makes it the active one. Existing
+// profiles are never replaced.
+func AddProfile(profile Profile) error {
+	if profile.Name == "" {
+		return fmt.Errorf("profile name is required")
+	}
+
+	for _, p := range AppConfig.Profiles {
+		if p.Name == profile.Name {
+			return fmt.Errorf("profile %q already exists", profile.Name)
+		}
+	}
+
+	overrides = Profile{}
+	AppConfig.Profiles = append(AppConfig.Profiles, profile)
+	AppConfig.ActiveProfile = profile.Name
+
+	return nil
+}
+
+var defaultTranscodeProfiles = map[string]TranscodeProfile{
+	"lan":    {MaxBitRate: 0, Format: "raw"},
+	"mobile": {MaxBitRate: 128, Format: "opus"},
+}
+
+func transcodeProfiles() map[string]TranscodeProfile {
+	if len(AppConfig.Stream.Profiles) == 0 {
+		return defaultTranscodeProfiles
+	}
+	return AppConfig.Stream.Profiles
+}
+
+func TranscodeProfileNames() []string {
+	names := []string{}
+	for name := range transcodeProfiles() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// CurrentTranscodeProfile returns the selected transcoding profile, or no
+// limits at all if it does not exist.
+func CurrentTranscodeProfile() (string, TranscodeProfile) {
+	name := AppConfig.Stream.Profile
+	if p, ok := transcodeProfiles()[name]; ok {
+		return name, p
+	}
+
+	return "", TranscodeProfile{}
+}
+
+func CycleTranscodeProfile() string {
+	names := TranscodeProfileNames()
+	if len(names) == 0 {
+		return ""
+	}
+
+	next := names[0]
+	for i, name := range names {
+		if name == AppConfig.Stream.Profile {
+			next = names[(i+1)%len(names)]
+			break
+		}
+	}
+
+	AppConfig.Stream.Profile = next
+	return next
+}
+
+var ReplayGainModes = []string{"off", "track", "album", "auto"}
+
+func CycleReplayGainMode() string {
+	next := ReplayGainModes[0]
+	for i, mode := range ReplayGainModes {
+		if mode == AppConfig.ReplayGain.Mode {
+			next = ReplayGainModes[(i+1)%len(ReplayGainModes)]
+			break
+		}
+	}
+
+	AppConfig.ReplayGain.Mode = next
+	return next
+}
+
+// MaxVolume is the highest volume the player may be set to.
+func MaxVolume() int {
+	if AppConfig.Player.VolumeMax > 100 {
+		return AppConfig.Player.VolumeMax
+	}
+	return 100
+}
+
+// SeekSteps returns the small and large seek steps in seconds.
+func SeekSteps() (float64, float64) {
+	small, large := 10, 60
+	if AppConfig.Player.SeekSmall > 0 {
+		small = AppConfig.Player.SeekSmall
+	}
+	if AppConfig.Player.SeekLarge > 0 {
+		large = AppConfig.Player.SeekLarge
+	}
+
+	return float64(small), float64(large)
+}
+
+var Speeds = []float64{0.5, 0.75, 1, 1.25, 1.5, 1.75, 2, 2.5, 3}
+
+// Speed returns the playback speed last used for a kind of content.
+func Speed(kind string) float64 {
+	if speed, ok := AppConfig.Player.Speeds[kind]; ok && speed > 0 {
+		return speed
+	}
+	return 1
+}
+
+func SetSpeed(kind string, speed float64) {
+	if AppConfig.Player.Speeds == nil {
+		AppConfig.Player.Speeds = make(map[string]float64)
+	}
+	AppConfig.Player.Speeds[kind] = speed
+}
+
+// PitchCorrection reports whether sped up audio keeps its pitch.
+func PitchCorrection() bool {
+	return AppConfig.Player.PitchCorrection == nil || *AppConfig.Player.PitchCorrection
+}
+
+// Center frequencies in Hz of the equalizer bands
+var EqualizerBands = []int{31, 62, 125, 250, 500, 1000, 2000, 4000, 8000, 16000}
+
+var builtinEqualizerPresets = []struct {
+	name  string
+	gains []float64
+}{
+	{"flat", []float64{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+	{"bass boost", []float64{6, 5, 4, 2, 0, 0, 0, 0, 0, 0}},
+	{"vocal", []float64{-2, -2, -1, 1, 3, 4, 3, 1, 0, -1}},
+	{"loudness", []float64{5, 4, 2, 0, -1, 0, -1, 1, 3, 4}},
+}
+
+// EqualizerPresetNames lists the built-in presets followed by the user's.
+func EqualizerPresetNames() []string {
+	names := []string{}
+	for _, preset := range builtinEqualizerPresets {
+		names = append(names, preset.name)
+	}
+
+	user := []string{}
+	for name := range AppConfig.Equalizer.Presets {
+		if _, builtin := builtinEqualizerPreset(name); !builtin {
+			user = append(user, name)
+		}
+	}
+	sort.Strings(user)
+
+	return append(names, user...)
+}
+
+func builtinEqualizerPreset(name string) ([]float64, bool) {
+	for _, preset := range builtinEqualizerPresets {
+		if preset.name == name {
+			return preset.gains, true
+		}
+	}
+	return nil, false
+}
+
+func EqualizerPreset(name string) ([]float64, bool) {
+	if gains, ok := builtinEqualizerPreset(name); ok {
+		return gains, true
+	}
+
+	gains, ok := AppConfig.Equalizer.Presets[name]
+	return normalizeGains(gains), ok
+}
+
+func SaveEqualizerPreset(name string, gains []float64) {
+	if AppConfig.Equalizer.Presets == nil {
+		AppConfig.Equalizer.Presets = make(map[string][]float64)
+	}
+
+	AppConfig.Equalizer.Presets[name] = append([]float64{}, gains...)
+	AppConfig.Equalizer.Preset = name
+}
+
+// EqualizerGains returns the gains picked by the user, one per band.
+func EqualizerGains() []float64 {
+	if len(AppConfig.Equalizer.Gains) == 0 {
+		if gains, ok := EqualizerPreset(AppConfig.Equalizer.Preset); ok {
+			return normalizeGains(gains)
+		}
+	}
+
+	return normalizeGains(AppConfig.Equalizer.Gains)
+}
+
+// GenreEqualizerPreset returns the preset configured for a genre, if any.
+func GenreEqualizerPreset(genre string) (string, bool) {
+	for g, preset := range AppConfig.Equalizer.Genres {
+		if strings.EqualFold(g, genre) {
+			return preset, true
+		}
+	}
+	return "", false
+}
+
+func normalizeGains(gains []float64) []float64 {
+	normalized := make([]float64, len(EqualizerBands))
+	copy(normalized, gains)
+	return normalized
+}
+
+var defaultColumns = []Column{
+	{Name: "title", Width: 40},
+	{Name: "artist", Width: 15},
+	{Name: "album", Width: 25},
+	{Name: "duration", Width: 0},
+}
+
+// SongColumns returns the column layout of a song table: search, album,
+// playlist or queue.
+func SongColumns(view string) []Column {
+	var columns []Column
+	switch view {
+	case "search":
+		columns = AppConfig.Columns.Search
+	case "album":
+		columns = AppConfig.Columns.Album
+	case "playlist":
+		columns = AppConfig.Columns.Playlist
+	case "queue":
+		columns = AppConfig.Columns.Queue
+	}
+
+	if len(columns) == 0 {
+		return defaultColumns
+	}
+	return columns
 }
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Client for the freedesktop Secret Service, as provided by GNOME Keyring,
+// KeePassXC and KWallet.
+
+const (
+	secretService       = "org.freedesktop.secrets"
+	secretServicePath   = "/org/freedesktop/secrets"
+	secretServiceIface  = "org.freedesktop.Secret.Service"
+	secretItemIface     = "org.freedesktop.Secret.Item"
+	secretPromptIface   = "org.freedesktop.Secret.Prompt"
+	secretDefaultFolder = "/org/freedesktop/secrets/aliases/default"
+)
+
+type keyringSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+type keyring struct {
+	conn    *dbus.Conn
+	session dbus.ObjectPath
+}
+
+func openKeyring() (*keyring, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, err
+	}
+
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err = conn.Object(secretService, secretServicePath).
+		Call(secretServiceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).
+		Store(&output, &session)
+	if err != nil {
+		return nil, fmt.Errorf("secret service unavailable: %v", err)
+	}
+
+	return &keyring{conn: conn, session: session}, nil
+}
+
+func (k *keyring) close() {
+	_ = k.conn.Object(secretService, k.session).Call("org.freedesktop.Secret.Session.Close", 0).Err
+}
+
+func keyringAttributes(profile Profile) map[string]string {
+	return map[string]string{
+		"application": "subtui",
+		"server":      profile.URL,
+		"username":    profile.Username,
+	}
+}
+
+func (k *keyring) lookup(profile Profile) (string, error) {
+	var unlocked, locked []dbus.ObjectPath
+	err := k.conn.Object(secretService, secretServicePath).
+		Call(secretServiceIface+".SearchItems", 0, keyringAttributes(profile)).
+		Store(&unlocked, &locked)
+	if err != nil {
+		return "", err
+	}
+
+	if len(unlocked) == 0 && len(locked) > 0 {
+		if err := k.unlock(locked[:1]); err != nil {
+			return "", err
+		}
+		unlocked = locked[:1]
+	}
+
+	if len(unlocked) == 0 {
+		return "", nil
+	}
+
+	var secret keyringSecret
+	err = k.conn.Object(secretService, unlocked[0]).
+		Call(secretItemIface+".GetSecret", 0, k.session).
+		Store(&secret)
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret.Value), nil
+}
+
+func (k *keyring) store(profile Profile) error {
+	collection := dbus.ObjectPath(secretDefaultFolder)
+	if err := k.unlock([]dbus.ObjectPath{collection}); err != nil {
+		return err
+	}
+
+	properties := map[string]dbus.Variant{
+		"org.freedesktop.Secret.Item.Label":      dbus.MakeVariant(fmt.Sprintf("SubTUI %s@%s", profile.Username, profile.URL)),
+		"org.freedesktop.Secret.Item.Attributes": dbus.MakeVariant(keyringAttributes(profile)),
+	}
+	secret := keyringSecret{
+		Session:     k.session,
+		Value:       []byte(profile.Password),
+		ContentType: "text/plain",
+	}
+
+	var item, prompt dbus.ObjectPath
+	err := k.conn.Object(secretService, collection).
+		Call("org.freedesktop.Secret.Collection.CreateItem", 0, properties, secret, true).
+		Store(&item, &prompt)
+	if err != nil {
+		return fmt.Errorf("could not store password in keyring: %v", err)
+	}
+
+	return k.prompt(prompt)
+}
+
+func (k *keyring) unlock(objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err := k.conn.Object(secretService, secretServicePath).
+		Call(secretServiceIface+".Unlock", 0, objects).
+		Store(&unlocked, &prompt)
+	if err != nil {
+		return fmt.Errorf("could not unlock keyring: %v", err)
+	}
+
+	return k.prompt(prompt)
+}
+
+// prompt shows a keyring dialog, e.g. for the unlock password, and waits for
+// the user to finish it.
+func (k *keyring) prompt(prompt dbus.ObjectPath) error {
+	if prompt == "/" || prompt == "" {
+		return nil
+	}
+
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(prompt),
+		dbus.WithMatchInterface(secretPromptIface),
+		dbus.WithMatchMember("Completed"),
+	}
+	if err := k.conn.AddMatchSignal(match...); err != nil {
+		return err
+	}
+	defer func() { _ = k.conn.RemoveMatchSignal(match...) }()
+
+	signals := make(chan *dbus.Signal, 4)
+	k.conn.Signal(signals)
+	defer k.conn.RemoveSignal(signals)
+
+	if err := k.conn.Object(secretService, prompt).Call(secretPromptIface+".Prompt", 0, "").Err; err != nil {
+		return err
+	}
+
+	timeout := time.After(2 * time.Minute)
+	for {
+		select {
+		case signal := <-signals:
+			if signal.Path != prompt || signal.Name != secretPromptIface+".Completed" {
+				continue
+			}
+			if dismissed, _ := signal.Body[0].(bool); dismissed {
+				return fmt.Errorf("keyring prompt dismissed")
+			}
+			return nil
+		case <-timeout:
+			return fmt.Errorf("keyring prompt timed out")
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+var (
+	clientMu  sync.Mutex
+	client    *http.Client
+	clientKey string
+)
+
+// httpClient returns a client configured with the TLS and proxy settings of
+// the current profile.
+func httpClient() (*http.Client, error) {
+	network := CurrentProfile().Network
+	key := fmt.Sprintf("%v", network)
+
+	clientMu.Lock()
+	defer clientMu.Unlock()
+
+	if client != nil && key == clientKey {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: network.InsecureSkipVerify}
+
+	if network.CAFile != "" {
+		pem, err := os.ReadFile(network.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", network.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if network.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(network.ClientCert, network.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	if network.Proxy != "" {
+		proxy, err := url.Parse(network.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	client = &http.Client{Transport: transport}
+	clientKey = key
+
+	return client, nil
+}
+
+// httpGet requests url with the extra headers of the current profile.
+func httpGet(url string) (*http.Response, error) {
+	c, err := httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range CurrentProfile().Network.Headers {
+		req.Header.Set(name, value)
+	}
+
+	return c.Do(req)
+}
+
+// InsecureTLS reports whether the current profile skips certificate checks.
+func InsecureTLS() bool {
+	return CurrentProfile().Network.InsecureSkipVerify
+}
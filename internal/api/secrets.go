@@ -0,0 +1,417 @@
+package api
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	SecretsAuto      = "auto"
+	SecretsKeyring   = "keyring"
+	SecretsEncrypted = "encrypted"
+	SecretsPlain     = "plain"
+)
+
+// PassphrasePrompt asks for the passphrase of the encrypted password store
+// when SUBTUI_PASSPHRASE is not set.
+var PassphrasePrompt func() (string, error)
+
+var (
+	secretsBackend string
+	passphrase     string
+
+	// A profile in the config file has its password in plaintext
+	plaintextFound bool
+
+	// Passwords already in the secret store, so saving the config does not
+	// rewrite them every time
+	storedSecrets = make(map[string]string)
+)
+
+func storedSecretKey(p Profile) string {
+	if p.EncryptedPassword != "" {
+		return p.EncryptedPassword
+	}
+	return p.URL + "\x00" + p.Username
+}
+
+// SecretsBackend returns where passwords are stored. auto prefers the
+// desktop keyring and falls back to encryption.
+func SecretsBackend() string {
+	if secretsBackend != "" {
+		return secretsBackend
+	}
+
+	switch AppConfig.Secrets.Backend {
+	case SecretsKeyring, SecretsEncrypted, SecretsPlain:
+		secretsBackend = AppConfig.Secrets.Backend
+	default:
+		secretsBackend = SecretsEncrypted
+		if k, err := openKeyring(); err == nil {
+			k.close()
+			secretsBackend = SecretsKeyring
+		}
+	}
+
+	return secretsBackend
+}
+
+// PrepareSecrets runs before the TUI takes the terminal. It unlocks the
+// active profile, asks for the passphrase if switching to or saving other
+// profiles will need it, and moves plaintext passwords into the secret store.
+// Only unlocking the active profile has to succeed. A password given as an
+// override leaves the secret store alone.
+func PrepareSecrets() error {
+	if overrides.Password != "" {
+		return nil
+	}
+
+	if i := activeProfileIndex(); i >= 0 && AppConfig.Profiles[i].Password == "" {
+		if err := unlockSecret(&AppConfig.Profiles[i]); err != nil {
+			return err
+		}
+	}
+
+	if SecretsBackend() == SecretsEncrypted {
+		needed := false
+		for _, p := range AppConfig.Profiles {
+			if p.PasswordCommand == "" {
+				needed = true
+			}
+		}
+		if needed {
+			if _, err := getPassphrase(); err != nil {
+				log.Printf("secrets: %v", err)
+			}
+		}
+	}
+
+	// Move plaintext passwords into the secret store, without asking again
+	// for a passphrase that was just refused
+	if plaintextFound && SecretsBackend() != SecretsPlain && !NeedsPassphrase() {
+		if err := SaveConfig(); err != nil {
+			log.Printf("secrets: could not migrate plaintext password, keeping it: %v", err)
+		} else {
+			plaintextFound = false
+		}
+	}
+
+	return nil
+}
+
+// NeedsPassphrase reports whether saving a new password would have to ask
+// for the passphrase of the encrypted store.
+func NeedsPassphrase() bool {
+	return SecretsBackend() == SecretsEncrypted && passphrase == "" && os.Getenv("SUBTUI_PASSPHRASE") == ""
+}
+
+// SetPassphrase sets the passphrase of the encrypted store, e.g. entered on
+// the login screen. It is checked against a stored password if there is one.
+func SetPassphrase(p string) error {
+	if p == "" {
+		return fmt.Errorf("empty passphrase")
+	}
+
+	for _, profile := range AppConfig.Profiles {
+		if profile.EncryptedPassword != "" {
+			if _, err := decryptSecret(profile.EncryptedPassword, p); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	passphrase = p
+	return nil
+}
+
+func getPassphrase() (string, error) {
+	if passphrase != "" {
+		return passphrase, nil
+	}
+
+	if env := os.Getenv("SUBTUI_PASSPHRASE"); env != "" {
+		passphrase = env
+		return passphrase, nil
+	}
+
+	if PassphrasePrompt == nil {
+		return "", fmt.Errorf("no passphrase for the encrypted password store, set SUBTUI_PASSPHRASE")
+	}
+
+	p, err := PassphrasePrompt()
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", fmt.Errorf("empty passphrase")
+	}
+
+	passphrase = p
+	return passphrase, nil
+}
+
+// UnlockProfile fills in the password of a profile from its password command
+// or the secret store, unless it is already known. Profiles are unlocked
+// before switching to them, so a failure leaves the active one in place.
+func UnlockProfile(name string) error {
+	for i := range AppConfig.Profiles {
+		if p := &AppConfig.Profiles[i]; p.Name == name && p.Password == "" {
+			return unlockSecret(p)
+		}
+	}
+
+	return nil
+}
+
+func unlockSecret(p *Profile) error {
+	switch {
+	case p.PasswordCommand != "":
+		password, err := runPasswordCommand(p.PasswordCommand)
+		if err != nil {
+			return fmt.Errorf("password_command of profile %q: %v", p.Name, err)
+		}
+		p.Password = password
+
+	case p.EncryptedPassword != "":
+		key, err := getPassphrase()
+		if err != nil {
+			return err
+		}
+		password, err := decryptSecret(p.EncryptedPassword, key)
+		if err != nil {
+			return fmt.Errorf("could not decrypt password of profile %q: %v", p.Name, err)
+		}
+		p.Password = password
+		storedSecrets[storedSecretKey(*p)] = password
+
+	case SecretsBackend() == SecretsKeyring:
+		k, err := openKeyring()
+		if err != nil {
+			return err
+		}
+		password, err := k.lookup(*p)
+		k.close()
+		if err != nil {
+			return fmt.Errorf("could not read password of profile %q from keyring: %v", p.Name, err)
+		}
+		p.Password = password
+		storedSecrets[storedSecretKey(*p)] = password
+	}
+
+	return nil
+}
+
+// storeSecrets saves the passwords of all profiles in the secret store and
+// returns the profiles as they should be written to disk. A password the
+// store cannot take stays in plaintext rather than being lost.
+func storeSecrets() ([]Profile, error) {
+	backend := SecretsBackend()
+
+	var k *keyring
+	defer func() {
+		if k != nil {
+			k.close()
+		}
+	}()
+
+	profiles := make([]Profile, len(AppConfig.Profiles))
+	for i := range AppConfig.Profiles {
+		p := &AppConfig.Profiles[i]
+
+		plain := backend == SecretsPlain && p.PasswordCommand == ""
+		if err := storeSecret(p, backend, &k); err != nil {
+			log.Printf("secrets: keeping password of profile %q in the config file: %v", p.Name, err)
+			plain = true
+		}
+
+		profiles[i] = *p
+		if !plain {
+			profiles[i].Password = ""
+		}
+	}
+
+	return profiles, nil
+}
+
+func storeSecret(p *Profile, backend string, k **keyring) error {
+	switch {
+	case p.PasswordCommand != "":
+		p.EncryptedPassword = ""
+
+	case backend == SecretsKeyring:
+		// Profiles that were never unlocked keep what they have
+		if p.Password == "" {
+			break
+		}
+		if stored, ok := storedSecrets[storedSecretKey(*p)]; ok && stored == p.Password {
+			p.EncryptedPassword = ""
+			break
+		}
+
+		if *k == nil {
+			var err error
+			if *k, err = openKeyring(); err != nil {
+				return err
+			}
+		}
+		if err := (*k).store(*p); err != nil {
+			return err
+		}
+		p.EncryptedPassword = ""
+		storedSecrets[storedSecretKey(*p)] = p.Password
+
+	case backend == SecretsEncrypted:
+		// Profiles that were never unlocked keep what they have
+		if stored, ok := storedSecrets[p.EncryptedPassword]; p.Password == "" || (ok && stored == p.Password) {
+			break
+		}
+
+		key, err := getPassphrase()
+		if err != nil {
+			return err
+		}
+		encrypted, err := encryptSecret(p.Password, key)
+		if err != nil {
+			return err
+		}
+		p.EncryptedPassword = encrypted
+		storedSecrets[p.EncryptedPassword] = p.Password
+
+	default:
+		if p.Password != "" {
+			p.EncryptedPassword = ""
+		}
+	}
+
+	return nil
+}
+
+func runPasswordCommand(command string) (string, error) {
+	out, err := exec.Command("sh", "-c", command).Output()
+	if err != nil {
+		return "", err
+	}
+
+	return commandPassword(string(out)), nil
+}
+
+// Tools like pass print the password on the first line
+func commandPassword(out string) string {
+	password, _, _ := strings.Cut(out, "\n")
+	return strings.TrimRight(password, "\r")
+}
+
+// PasswordCommand returns the password_command that still has to unlock a
+// profile, or nil. It may ask for a PIN, e.g. through gpg, so once the TUI
+// owns the terminal it runs with the terminal handed over, and its output is
+// passed to UnlockWithOutput.
+func PasswordCommand(name string) *exec.Cmd {
+	for _, p := range AppConfig.Profiles {
+		if p.Name == name && p.Password == "" && p.PasswordCommand != "" {
+			return exec.Command("sh", "-c", p.PasswordCommand)
+		}
+	}
+
+	return nil
+}
+
+// UnlockWithOutput sets the password of a profile from what its
+// password_command printed.
+func UnlockWithOutput(name string, out string) error {
+	password := commandPassword(out)
+	if password == "" {
+		return fmt.Errorf("password_command of profile %q printed no password", name)
+	}
+
+	for i := range AppConfig.Profiles {
+		if p := &AppConfig.Profiles[i]; p.Name == name {
+			p.Password = password
+		}
+	}
+
+	return nil
+}
+
+const (
+	secretSaltSize   = 16
+	secretIterations = 600000
+)
+
+func secretCipher(key string, salt []byte) (cipher.AEAD, error) {
+	derived, err := pbkdf2.Key(sha256.New, key, salt, secretIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret returns base64 of salt, nonce and AES-GCM ciphertext.
+func encryptSecret(plaintext string, key string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	salt := make([]byte, secretSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	aead, err := secretCipher(key, salt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	data := append(salt, nonce...)
+	data = aead.Seal(data, nonce, []byte(plaintext), nil)
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func decryptSecret(encoded string, key string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) < secretSaltSize {
+		return "", fmt.Errorf("invalid encrypted password")
+	}
+
+	aead, err := secretCipher(key, data[:secretSaltSize])
+	if err != nil {
+		return "", err
+	}
+
+	data = data[secretSaltSize:]
+	if len(data) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted password")
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("wrong passphrase")
+	}
+
+	return string(plaintext), nil
+}
@@ -0,0 +1,50 @@
+package api
+
+import "testing"
+
+func TestSecretRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		plaintext string
+		key       string
+		openWith  string
+		want      string
+		wantErr   bool
+	}{
+		{"round trip", "hunter2", "passphrase", "passphrase", "hunter2", false},
+		{"unicode", "pässwörd ✓", "clé", "clé", "pässwörd ✓", false},
+		{"empty password", "", "passphrase", "passphrase", "", false},
+		{"wrong passphrase", "hunter2", "passphrase", "other", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := encryptSecret(tt.plaintext, tt.key)
+			if err != nil {
+				t.Fatalf("encrypt: %v", err)
+			}
+			if tt.plaintext == "" {
+				if encrypted != "" {
+					t.Fatalf("empty password encrypted to %q", encrypted)
+				}
+				return
+			}
+
+			got, err := decryptSecret(encrypted, tt.openWith)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decrypt error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("decrypted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecryptSecretRejectsGarbage(t *testing.T) {
+	for _, encoded := range []string{"not base64!", "c2hvcnQ=", ""} {
+		if _, err := decryptSecret(encoded, "passphrase"); err == nil {
+			t.Errorf("decrypting %q succeeded", encoded)
+		}
+	}
+}
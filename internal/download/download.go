@@ -0,0 +1,465 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+)
+
+const (
+	StatusQueued = iota
+	StatusDownloading
+	StatusDone
+	StatusFailed
+)
+
+// Job is a single song download requested during this session.
+type Job struct {
+	Song       api.Song
+	Status     int
+	Downloaded int64
+	Total      int64
+	Err        error
+}
+
+// entry is a downloaded file tracked in the on-disk index.
+type entry struct {
+	Song     api.Song  `json:"song"`
+	File     string    `json:"file"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+var (
+	mu      sync.Mutex
+	jobs    []*Job
+	entries map[string]*entry
+	loaded  string
+	dirty   bool // LastUsed changed since the index was saved
+	wake    = make(chan struct{}, 1)
+	start   sync.Once
+
+	// IDs in entries, replaced whenever they change, for lock-free lookups
+	// while rendering
+	downloaded atomic.Pointer[map[string]bool]
+)
+
+// How long LastUsed updates are batched before the index is written
+const usageFlushDelay = 30 * time.Second
+
+func dataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+// Dir is where downloads of the current server are stored.
+func Dir() (string, error) {
+	dir := api.AppConfig.Downloads.Dir
+	if dir == "" {
+		base, err := dataDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(base, "subtui", "downloads")
+	}
+
+	return filepath.Join(dir, api.ServerKey()), nil
+}
+
+// ensureLoaded reads the index of the current server. Callers hold mu.
+func ensureLoaded() {
+	dir, err := Dir()
+	if err != nil || dir == loaded {
+		return
+	}
+
+	if dirty {
+		_ = saveIndex()
+	}
+
+	entries = make(map[string]*entry)
+	loaded = dir
+
+	if data, err := os.ReadFile(filepath.Join(dir, "index.json")); err == nil {
+		_ = json.Unmarshal(data, &entries)
+	}
+	publish()
+}
+
+// publish refreshes the set IsDownloaded reads. Callers hold mu.
+func publish() {
+	set := make(map[string]bool, len(entries))
+	for id := range entries {
+		set[id] = true
+	}
+	downloaded.Store(&set)
+}
+
+// Reload reads the index of the current server, e.g. after switching profiles.
+func Reload() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	ensureLoaded()
+}
+
+// saveIndex writes the index of the current server. Callers hold mu.
+func saveIndex() error {
+	if err := os.MkdirAll(loaded, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(loaded, "index.json.tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(loaded, "index.json")); err != nil {
+		return err
+	}
+
+	dirty = false
+	return nil
+}
+
+// Flush writes pending LastUsed updates, e.g. before exiting.
+func Flush() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if dirty {
+		_ = saveIndex()
+	}
+}
+
+// LocalPath returns the downloaded file of a song, if there is one.
+func LocalPath(id string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	ensureLoaded()
+
+	e, ok := entries[id]
+	if !ok {
+		return "", false
+	}
+
+	path := filepath.Join(loaded, e.File)
+	if _, err := os.Stat(path); err != nil {
+		delete(entries, id)
+		publish()
+		_ = saveIndex()
+		return "", false
+	}
+
+	// Only the eviction order depends on it, so writes are batched
+	e.LastUsed = time.Now()
+	if !dirty {
+		dirty = true
+		time.AfterFunc(usageFlushDelay, Flush)
+	}
+
+	return path, true
+}
+
+func IsDownloaded(id string) bool {
+	set := downloaded.Load()
+	if set == nil {
+		Reload()
+		if set = downloaded.Load(); set == nil {
+			return false
+		}
+	}
+
+	return (*set)[id]
+}
+
+// Usage returns the number of downloaded songs and their total size.
+func Usage() (int, int64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	ensureLoaded()
+
+	var size int64
+	for _, e := range entries {
+		size += e.Size
+	}
+
+	return len(entries), size
+}
+
+// Jobs returns a snapshot of the downloads requested this session.
+func Jobs() []Job {
+	mu.Lock()
+	defer mu.Unlock()
+
+	snapshot := make([]Job, len(jobs))
+	for i, job := range jobs {
+		snapshot[i] = *job
+	}
+
+	return snapshot
+}
+
+// Active reports whether downloads are queued or running.
+func Active() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, job := range jobs {
+		if job.Status == StatusQueued || job.Status == StatusDownloading {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Enqueue schedules songs for download, skipping ones already downloaded or queued.
+func Enqueue(songs []api.Song) {
+	start.Do(func() { go worker() })
+
+	mu.Lock()
+	ensureLoaded()
+
+	added := 0
+	for _, song := range songs {
+		if _, ok := entries[song.ID]; ok {
+			continue
+		}
+
+		queued := false
+		for _, job := range jobs {
+			if job.Song.ID == song.ID && job.Status != StatusFailed {
+				queued = true
+				break
+			}
+		}
+		if queued {
+			continue
+		}
+
+		jobs = append(jobs, &Job{Song: song})
+		added++
+	}
+	mu.Unlock()
+
+	if added > 0 {
+		signal()
+	}
+}
+
+// Retry re-queues a failed job.
+func Retry(index int) {
+	mu.Lock()
+	if index < 0 || index >= len(jobs) || jobs[index].Status != StatusFailed {
+		mu.Unlock()
+		return
+	}
+
+	job := jobs[index]
+	job.Status = StatusQueued
+	job.Err = nil
+	job.Downloaded = 0
+	mu.Unlock()
+
+	signal()
+}
+
+// signal wakes the worker without blocking; one pending wake-up is enough as
+// the worker drains every queued job.
+func signal() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// nextJob returns the oldest queued job, or nil.
+func nextJob() *Job {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, job := range jobs {
+		if job.Status == StatusQueued {
+			return job
+		}
+	}
+
+	return nil
+}
+
+func worker() {
+	for range wake {
+		for job := nextJob(); job != nil; job = nextJob() {
+			finish(job, fetch(job))
+		}
+	}
+}
+
+func finish(job *Job, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err != nil {
+		job.Status = StatusFailed
+		job.Err = err
+	} else {
+		job.Status = StatusDone
+	}
+}
+
+type progressWriter struct {
+	job *Job
+}
+
+func (w progressWriter) Write(p []byte) (int, error) {
+	mu.Lock()
+	w.job.Downloaded += int64(len(p))
+	mu.Unlock()
+
+	return len(p), nil
+}
+
+func fetch(job *Job) error {
+	mu.Lock()
+	ensureLoaded()
+	dir := loaded
+	job.Status = StatusDownloading
+	mu.Unlock()
+
+	resp, err := api.SubsonicDownload(job.Song.ID)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	mu.Lock()
+	job.Total = resp.ContentLength
+	mu.Unlock()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// The ID comes from the server, so it is hashed rather than trusted as a
+	// file name
+	sum := sha256.Sum256([]byte(job.Song.ID))
+	name := hex.EncodeToString(sum[:16]) + fileExtension(resp.Header.Get("Content-Disposition"), resp.Header.Get("Content-Type"))
+	tmp := filepath.Join(dir, name+".part")
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	size, err := io.Copy(io.MultiWriter(file, progressWriter{job}), resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("download interrupted: %v", err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// The profile may have changed while downloading. The file would not be
+	// in any index, so it could never be played or evicted.
+	ensureLoaded()
+	if loaded != dir {
+		_ = os.Remove(filepath.Join(dir, name))
+		return fmt.Errorf("profile changed while downloading")
+	}
+
+	entries[job.Song.ID] = &entry{
+		Song:     job.Song,
+		File:     name,
+		Size:     size,
+		LastUsed: time.Now(),
+	}
+	evict()
+	publish()
+
+	return saveIndex()
+}
+
+// evict removes the least recently used files until the cache fits the
+// configured size. Callers hold mu.
+func evict() {
+	limit := api.AppConfig.Downloads.MaxSizeMB * 1024 * 1024
+	if limit <= 0 {
+		return
+	}
+
+	var total int64
+	ids := make([]string, 0, len(entries))
+	for id, e := range entries {
+		total += e.Size
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return entries[ids[i]].LastUsed.Before(entries[ids[j]].LastUsed)
+	})
+
+	// Never evict the newest file, even if it alone exceeds the limit
+	for _, id := range ids[:len(ids)-1] {
+		if total <= limit {
+			break
+		}
+
+		e := entries[id]
+		_ = os.Remove(filepath.Join(loaded, e.File))
+		total -= e.Size
+		delete(entries, id)
+	}
+}
+
+// Extensions are taken from server headers, so only plain ones are used
+var extensionPattern = regexp.MustCompile(`^\.[A-Za-z0-9]{1,8}$`)
+
+func fileExtension(disposition string, contentType string) string {
+	if _, params, err := mime.ParseMediaType(disposition); err == nil {
+		if ext := filepath.Ext(params["filename"]); extensionPattern.MatchString(ext) {
+			return ext
+		}
+	}
+
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 && extensionPattern.MatchString(exts[0]) {
+		return exts[0]
+	}
+
+	return ""
+}
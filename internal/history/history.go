@@ -0,0 +1,220 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+)
+
+// Play is one listen of a song, from the moment it started until playback
+// moved on.
+type Play struct {
+	SongID    string    `json:"song_id"`
+	Title     string    `json:"title"`
+	Artist    string    `json:"artist"`
+	Album     string    `json:"album"`
+	Genre     string    `json:"genre"`
+	Started   time.Time `json:"started"`
+	Ended     time.Time `json:"ended"`
+	Duration  float64   `json:"duration"` // Length of the song in seconds
+	Listened  float64   `json:"listened"` // Seconds actually played
+	Completed bool      `json:"completed"`
+}
+
+// Skipped plays are the ones left before the end.
+func (p Play) Skipped() bool {
+	return !p.Completed
+}
+
+var mu sync.Mutex
+
+func historyPath() (string, error) {
+	dir, err := api.StateDir()
+	if err != nil {
+		return "", err
+	}
+
+	// One history per server and user, one play per line
+	return filepath.Join(dir, fmt.Sprintf("history-%s.jsonl", api.ServerKey())), nil
+}
+
+// Record appends a play to the history of the current server.
+func Record(play Play) error {
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(play)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Plays returns the plays that started after since, oldest first. Lines that
+// cannot be read, e.g. cut off by a crash, are skipped.
+func Plays(since time.Time) ([]Play, error) {
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	plays := []Play{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var play Play
+		if json.Unmarshal(scanner.Bytes(), &play) != nil || play.Started.Before(since) {
+			continue
+		}
+		plays = append(plays, play)
+	}
+
+	return plays, scanner.Err()
+}
+
+// Count totals the plays of one song, artist, album or genre.
+type Count struct {
+	Name    string
+	Plays   int
+	Skips   int
+	Seconds float64 // Listened
+	Length  float64 // Total length of the songs played
+}
+
+// Percent is how much of the songs was listened to on average.
+func (c Count) Percent() float64 {
+	if c.Length <= 0 {
+		return 0
+	}
+	return min(c.Seconds/c.Length*100, 100)
+}
+
+func (c Count) SkipRate() float64 {
+	if c.Plays == 0 {
+		return 0
+	}
+	return float64(c.Skips) / float64(c.Plays) * 100
+}
+
+type Stats struct {
+	Total   Count
+	Songs   []Count // Most played first
+	Artists []Count
+	Albums  []Count
+	Genres  []Count
+
+	// Seconds listened by weekday, Monday first, and hour of the day
+	Hours [7][24]float64
+}
+
+// Compute summarizes the plays that started after since.
+func Compute(since time.Time) (Stats, error) {
+	plays, err := Plays(since)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats := Stats{}
+	songs := map[string]*Count{}
+	artists := map[string]*Count{}
+	albums := map[string]*Count{}
+	genres := map[string]*Count{}
+
+	// Songs are told apart by ID, and shown with the tags of their latest
+	// play. Artists, albums and genres only have their name.
+	add := func(counts map[string]*Count, key string, name string, play Play) {
+		if key == "" {
+			return
+		}
+		if counts[key] == nil {
+			counts[key] = &Count{}
+		}
+		counts[key].Name = name
+		counts[key].add(play)
+	}
+
+	for _, play := range plays {
+		stats.Total.add(play)
+		add(songs, play.SongID, play.Title+" - "+play.Artist, play)
+		add(artists, play.Artist, play.Artist, play)
+		add(albums, play.Album, play.Album, play)
+		add(genres, play.Genre, play.Genre, play)
+
+		local := play.Started.Local()
+		weekday := (int(local.Weekday()) + 6) % 7
+		stats.Hours[weekday][local.Hour()] += play.Listened
+	}
+
+	stats.Songs = ranked(songs)
+	stats.Artists = ranked(artists)
+	stats.Albums = ranked(albums)
+	stats.Genres = ranked(genres)
+
+	return stats, nil
+}
+
+func (c *Count) add(play Play) {
+	c.Plays++
+	c.Seconds += play.Listened
+	c.Length += play.Duration
+	if play.Skipped() {
+		c.Skips++
+	}
+}
+
+func ranked(counts map[string]*Count) []Count {
+	list := make([]Count, 0, len(counts))
+	for _, c := range counts {
+		list = append(list, *c)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Plays != list[j].Plays {
+			return list[i].Plays > list[j].Plays
+		}
+		if list[i].Seconds != list[j].Seconds {
+			return list[i].Seconds > list[j].Seconds
+		}
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
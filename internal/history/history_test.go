@@ -0,0 +1,87 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+)
+
+func TestCompute(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	api.AppConfig = api.Config{
+		Profiles:      []api.Profile{{Name: "test", URL: "http://127.0.0.1:1", Username: "user"}},
+		ActiveProfile: "test",
+	}
+
+	start := time.Date(2026, 3, 2, 20, 0, 0, 0, time.Local) // A Monday
+	plays := []Play{
+		// Two songs with the same title and artist
+		{SongID: "1", Title: "Intro", Artist: "Band", Album: "First", Genre: "Rock", Duration: 100, Listened: 100, Completed: true},
+		{SongID: "2", Title: "Intro", Artist: "Band", Album: "Second", Genre: "Rock", Duration: 100, Listened: 50},
+		// The tags of a song changed between plays
+		{SongID: "1", Title: "Intro (Remastered)", Artist: "Band", Album: "First", Genre: "Rock", Duration: 100, Listened: 100, Completed: true},
+		{SongID: "3", Title: "Outro", Artist: "Other", Album: "Second", Duration: 200, Listened: 20},
+	}
+	for i, play := range plays {
+		play.Started = start.Add(time.Duration(i) * time.Minute)
+		play.Ended = play.Started.Add(time.Duration(play.Listened) * time.Second)
+		if err := Record(play); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Plays before since are left out
+	old := Play{SongID: "3", Title: "Outro", Artist: "Other", Started: start.Add(-48 * time.Hour), Listened: 200}
+	if err := Record(old); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := Compute(start.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Total.Plays != 4 || stats.Total.Skips != 2 || stats.Total.Seconds != 270 {
+		t.Fatalf("total %+v, want 4 plays, 2 skips and 270 seconds", stats.Total)
+	}
+
+	tests := []struct {
+		name   string
+		counts []Count
+		want   []Count
+	}{
+		{"songs", stats.Songs, []Count{
+			{Name: "Intro (Remastered) - Band", Plays: 2, Seconds: 200, Length: 200},
+			{Name: "Intro - Band", Plays: 1, Skips: 1, Seconds: 50, Length: 100},
+			{Name: "Outro - Other", Plays: 1, Skips: 1, Seconds: 20, Length: 200},
+		}},
+		{"artists", stats.Artists, []Count{
+			{Name: "Band", Plays: 3, Skips: 1, Seconds: 250, Length: 300},
+			{Name: "Other", Plays: 1, Skips: 1, Seconds: 20, Length: 200},
+		}},
+		{"albums", stats.Albums, []Count{
+			{Name: "First", Plays: 2, Seconds: 200, Length: 200},
+			{Name: "Second", Plays: 2, Skips: 2, Seconds: 70, Length: 300},
+		}},
+		{"genres without a genre", stats.Genres, []Count{
+			{Name: "Rock", Plays: 3, Skips: 1, Seconds: 250, Length: 300},
+		}},
+	}
+
+	for _, tt := range tests {
+		if len(tt.counts) != len(tt.want) {
+			t.Errorf("%s: %+v, want %+v", tt.name, tt.counts, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if tt.counts[i] != tt.want[i] {
+				t.Errorf("%s[%d]: %+v, want %+v", tt.name, i, tt.counts[i], tt.want[i])
+			}
+		}
+	}
+
+	if listened := stats.Hours[0][20]; listened != 270 {
+		t.Fatalf("%g seconds listened on Monday at 20:00, want 270", listened)
+	}
+}
@@ -0,0 +1,234 @@
+package instance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Command is what a second subtui invocation forwards to the running one.
+// An empty Action only checks that an instance is running.
+type Command struct {
+	Action string `json:"action"` // play, queue or toggle
+	Query  string `json:"query,omitempty"`
+}
+
+var ErrNotRunning = errors.New("SubTUI is not running")
+
+// ParseCommand reads a command from the arguments left after the flags.
+func ParseCommand(args []string) (Command, error) {
+	if len(args) == 0 {
+		return Command{}, nil
+	}
+
+	cmd := Command{Action: args[0], Query: strings.Join(args[1:], " ")}
+	switch cmd.Action {
+	case "toggle":
+		if cmd.Query != "" {
+			return cmd, fmt.Errorf("toggle takes no arguments")
+		}
+	case "play", "queue":
+		if cmd.Query == "" {
+			return cmd, fmt.Errorf("%s needs a search query", cmd.Action)
+		}
+	default:
+		return cmd, fmt.Errorf("unknown command %q, expected play, queue or toggle", cmd.Action)
+	}
+
+	return cmd, nil
+}
+
+// Dir holds the sockets of running instances, in $XDG_RUNTIME_DIR when set.
+func Dir() string {
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("subtui-%d", os.Getuid()))
+	if runtime := os.Getenv("XDG_RUNTIME_DIR"); runtime != "" {
+		dir = filepath.Join(runtime, "subtui")
+	}
+
+	_ = os.MkdirAll(dir, 0700)
+	return dir
+}
+
+// MPVSocket is the IPC socket of the mpv owned by this process.
+func MPVSocket() string {
+	return filepath.Join(Dir(), fmt.Sprintf("mpv-%d.sock", os.Getpid()))
+}
+
+// CleanStale removes the mpv sockets of instances that are no longer running,
+// along with any mpv they left behind. Sockets of live instances are kept.
+func CleanStale() {
+	paths, _ := filepath.Glob(filepath.Join(Dir(), "mpv-*.sock"))
+	for _, path := range paths {
+		var pid int
+		if _, err := fmt.Sscanf(filepath.Base(path), "mpv-%d.sock", &pid); err != nil || pid == os.Getpid() || alive(pid) {
+			continue
+		}
+
+		log.Printf("instance: removing stale socket %s", path)
+		if orphan, err := mpvPID(path); err == nil {
+			log.Printf("instance: stopping orphaned mpv %d", orphan)
+			_ = syscall.Kill(orphan, syscall.SIGTERM)
+		}
+		_ = os.Remove(path)
+	}
+}
+
+// mpvPID asks the mpv still listening on a socket for its process ID.
+func mpvPID(path string) (int, error) {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+
+	if _, err := conn.Write([]byte(`{"command":["get_property","pid"],"request_id":1}` + "\n")); err != nil {
+		return 0, err
+	}
+
+	// Property change events may come before the reply
+	decoder := json.NewDecoder(conn)
+	for {
+		var reply struct {
+			RequestID int    `json:"request_id"`
+			Error     string `json:"error"`
+			Data      int    `json:"data"`
+		}
+		if err := decoder.Decode(&reply); err != nil {
+			return 0, err
+		}
+		if reply.RequestID != 1 {
+			continue
+		}
+		if reply.Error != "success" || reply.Data <= 0 {
+			return 0, fmt.Errorf("mpv did not report its pid: %s", reply.Error)
+		}
+		return reply.Data, nil
+	}
+}
+
+func alive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func controlSocket() string {
+	return filepath.Join(Dir(), "control.sock")
+}
+
+// Play and queue wait for the running instance to search the server
+const commandTimeout = 15 * time.Second
+
+type reply struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Send forwards cmd to the running instance, or returns ErrNotRunning.
+func Send(cmd Command) error {
+	conn, err := net.DialTimeout("unix", controlSocket(), time.Second)
+	if err != nil {
+		return ErrNotRunning
+	}
+	defer func() { _ = conn.Close() }()
+
+	_ = conn.SetDeadline(time.Now().Add(commandTimeout))
+
+	if err := json.NewEncoder(conn).Encode(cmd); err != nil {
+		return err
+	}
+
+	var r reply
+	if err := json.NewDecoder(conn).Decode(&r); err != nil {
+		return fmt.Errorf("no answer from SubTUI: %v", err)
+	}
+	if r.Error != "" {
+		return errors.New(r.Error)
+	}
+
+	return nil
+}
+
+// lockedListener keeps control.lock until it is closed.
+type lockedListener struct {
+	net.Listener
+	lock *os.File
+}
+
+func (l lockedListener) Close() error {
+	err := l.Listener.Close()
+	_ = l.lock.Close()
+	return err
+}
+
+// Listen passes forwarded commands to handle until the listener is closed,
+// which also removes the socket. The running instance holds a lock for as
+// long as it listens, so a socket left behind is replaced, but two instances
+// starting at once cannot both take it over.
+func Listen(handle func(Command) error) (net.Listener, error) {
+	lock, err := os.OpenFile(filepath.Join(Dir(), "control.lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = lock.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("SubTUI is already running")
+		}
+		return nil, err
+	}
+
+	_ = os.Remove(controlSocket())
+
+	socket, err := net.Listen("unix", controlSocket())
+	if err != nil {
+		_ = lock.Close()
+		return nil, err
+	}
+	listener := lockedListener{socket, lock}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn, handle)
+		}
+	}()
+
+	return listener, nil
+}
+
+func serve(conn net.Conn, handle func(Command) error) {
+	defer func() { _ = conn.Close() }()
+
+	_ = conn.SetDeadline(time.Now().Add(commandTimeout))
+
+	var cmd Command
+	if err := json.NewDecoder(conn).Decode(&cmd); err != nil {
+		return
+	}
+
+	r := reply{}
+	if cmd.Action != "" {
+		log.Printf("instance: received %s %q", cmd.Action, cmd.Query)
+		if err := handle(cmd); err != nil {
+			r.Error = err.Error()
+		}
+	}
+
+	_ = json.NewEncoder(conn).Encode(r)
+}
@@ -0,0 +1,162 @@
+package library
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+)
+
+const (
+	FieldTitle = iota
+	FieldArtist
+	FieldAlbum
+)
+
+// Result is a song matching a search, with the matched rune positions per
+// field so the UI can highlight them.
+type Result struct {
+	Song    api.Song
+	Score   int
+	Matches map[int][]int
+}
+
+// Search fuzzy-matches every whitespace separated word of the query against
+// the title, artist and album of each indexed song.
+func Search(query string, limit int) []Result {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return nil
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if index == nil {
+		return nil
+	}
+
+	results := []Result{}
+	for _, song := range index.Songs {
+		fields := [][]rune{
+			[]rune(strings.ToLower(song.Title)),
+			[]rune(strings.ToLower(song.Artist)),
+			[]rune(strings.ToLower(song.Album)),
+		}
+
+		total := 0
+		matches := map[int][]int{}
+		for _, word := range words {
+			best, bestField := -1, -1
+			var bestPositions []int
+
+			for field, text := range fields {
+				score, positions, ok := fuzzyMatch([]rune(word), text)
+				if ok && score > best {
+					best, bestField, bestPositions = score, field, positions
+				}
+			}
+
+			if bestField < 0 {
+				total = -1
+				break
+			}
+
+			total += best
+			matches[bestField] = append(matches[bestField], bestPositions...)
+		}
+
+		if total < 0 {
+			continue
+		}
+
+		results = append(results, Result{Song: song, Score: total, Matches: matches})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return len(results[i].Song.Title) < len(results[j].Song.Title)
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+// fuzzyMatch reports whether all runes of query appear in order in text.
+// Matches on word starts and consecutive runes score higher, gaps lower.
+func fuzzyMatch(query []rune, text []rune) (int, []int, bool) {
+	if len(query) == 0 || len(query) > len(text) {
+		return 0, nil, false
+	}
+
+	bestScore := -1
+	var bestPositions []int
+
+	// Try every occurrence of the first rune as a starting point
+	for start := 0; start <= len(text)-len(query); start++ {
+		if text[start] != query[0] {
+			continue
+		}
+
+		positions := []int{start}
+		score := matchBonus(text, start, -1)
+
+		q := 1
+		for i := start + 1; i < len(text) && q < len(query); i++ {
+			if text[i] != query[q] {
+				continue
+			}
+
+			score += matchBonus(text, i, positions[len(positions)-1])
+			positions = append(positions, i)
+			q++
+		}
+
+		if q < len(query) {
+			break
+		}
+
+		if start == 0 {
+			score += 10
+		}
+
+		if score > bestScore {
+			bestScore = score
+			bestPositions = positions
+		}
+	}
+
+	if bestScore < 0 {
+		return 0, nil, false
+	}
+
+	return bestScore, bestPositions, true
+}
+
+func matchBonus(text []rune, i int, prev int) int {
+	score := 1
+
+	if i == 0 || !unicode.IsLetter(text[i-1]) && !unicode.IsDigit(text[i-1]) {
+		score += 8
+	}
+
+	if prev >= 0 {
+		if i == prev+1 {
+			score += 6
+		} else {
+			gap := i - prev - 1
+			if gap > 5 {
+				gap = 5
+			}
+			score -= gap
+		}
+	}
+
+	return score
+}
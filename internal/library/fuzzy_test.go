@@ -0,0 +1,61 @@
+package library
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		query     string
+		text      string
+		ok        bool
+		positions []int
+	}{
+		{"abc", "abc", true, []int{0, 1, 2}},
+		{"ac", "abc", true, []int{0, 2}},
+		{"tl", "the long way", true, []int{0, 4}},
+		{"way", "the long way", true, []int{9, 10, 11}},
+		{"lw", "the long way", true, []int{4, 9}},
+		{"ca", "abc", false, nil},
+		{"abcd", "abc", false, nil},
+		{"", "abc", false, nil},
+		{"é", "café", true, []int{3}},
+	}
+
+	for _, tt := range tests {
+		score, positions, ok := fuzzyMatch([]rune(tt.query), []rune(tt.text))
+		if ok != tt.ok {
+			t.Errorf("fuzzyMatch(%q, %q) matched %v, want %v", tt.query, tt.text, ok, tt.ok)
+			continue
+		}
+		if !slices.Equal(positions, tt.positions) {
+			t.Errorf("fuzzyMatch(%q, %q) matched at %v, want %v", tt.query, tt.text, positions, tt.positions)
+		}
+		if ok && score <= 0 {
+			t.Errorf("fuzzyMatch(%q, %q) scored %d", tt.query, tt.text, score)
+		}
+	}
+}
+
+func TestFuzzyMatchRanking(t *testing.T) {
+	// Each query should score higher against the first text than the second
+	tests := []struct {
+		query  string
+		better string
+		worse  string
+	}{
+		{"love", "love song", "glove song"},
+		{"ls", "love song", "lost"},
+		{"lw", "long way", "slow"},
+		{"ac", "abc", "abxxxxxc"},
+	}
+
+	for _, tt := range tests {
+		better, _, _ := fuzzyMatch([]rune(tt.query), []rune(tt.better))
+		worse, _, _ := fuzzyMatch([]rune(tt.query), []rune(tt.worse))
+		if better <= worse {
+			t.Errorf("%q scored %d against %q and %d against %q", tt.query, better, tt.better, worse, tt.worse)
+		}
+	}
+}
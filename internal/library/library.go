@@ -0,0 +1,265 @@
+package library
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+)
+
+// Index is a local copy of the server library used for instant search.
+type Index struct {
+	Artists   []api.Artist
+	Albums    []api.Album
+	Songs     []api.Song
+	ScanCount int
+	LastScan  string
+	UpdatedAt time.Time
+}
+
+var (
+	mu      sync.RWMutex
+	index   *Index
+	syncing bool
+)
+
+func indexPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	// One index per server and user
+	name := fmt.Sprintf("library-%s.gob", api.ServerKey())
+
+	return filepath.Join(dir, "subtui", name), nil
+}
+
+func Load() error {
+	path, err := indexPath()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	var idx Index
+	if err := gob.NewDecoder(file).Decode(&idx); err != nil {
+		return fmt.Errorf("could not decode library index: %v", err)
+	}
+
+	mu.Lock()
+	index = &idx
+	mu.Unlock()
+
+	return nil
+}
+
+func save(idx *Index, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := gob.NewEncoder(file).Encode(idx); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Reset drops the in-memory index, e.g. after switching servers.
+func Reset() {
+	mu.Lock()
+	index = nil
+	mu.Unlock()
+}
+
+func SongCount() int {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if index == nil {
+		return 0
+	}
+	return len(index.Songs)
+}
+
+func Syncing() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return syncing
+}
+
+// Sync brings the index up to date. The first run walks the whole library,
+// later runs only fetch albums added since the last sync.
+func Sync() error {
+	mu.Lock()
+	if syncing {
+		mu.Unlock()
+		return nil
+	}
+	syncing = true
+	current := index
+	mu.Unlock()
+
+	defer func() {
+		mu.Lock()
+		syncing = false
+		mu.Unlock()
+	}()
+
+	// The profile may change while syncing, the result belongs to this one
+	key := api.ServerKey()
+	path, err := indexPath()
+	if err != nil {
+		return err
+	}
+
+	status, err := api.SubsonicGetScanStatus()
+	if err != nil {
+		status = &api.ScanStatus{}
+	}
+
+	var next *Index
+	switch {
+	case current == nil || len(current.Albums) == 0:
+		next, err = fullSync()
+	case status.Scanning:
+		return nil
+	case status.Count != 0 && status.Count == current.ScanCount && status.LastScan == current.LastScan:
+		return nil
+	case status.Count != 0 && status.Count < len(current.Songs):
+		// Songs were removed, which the newest list can't tell us about
+		next, err = fullSync()
+	default:
+		next, err = incrementalSync(current)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	next.ScanCount = status.Count
+	next.LastScan = status.LastScan
+	next.UpdatedAt = time.Now()
+
+	mu.Lock()
+	if api.ServerKey() != key {
+		mu.Unlock()
+		return nil
+	}
+	index = next
+	mu.Unlock()
+
+	return save(next, path)
+}
+
+func fullSync() (*Index, error) {
+	groups, err := api.SubsonicGetArtistsUncached()
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{}
+	for _, group := range groups {
+		for _, artist := range group.Artists {
+			idx.Artists = append(idx.Artists, artist)
+
+			albums, err := api.SubsonicGetArtistUncached(artist.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, album := range albums {
+				songs, err := api.SubsonicGetAlbumUncached(album.ID)
+				if err != nil {
+					return nil, err
+				}
+
+				idx.Albums = append(idx.Albums, album)
+				idx.Songs = append(idx.Songs, songs...)
+			}
+		}
+	}
+
+	return idx, nil
+}
+
+func incrementalSync(current *Index) (*Index, error) {
+	next := &Index{
+		Artists: append([]api.Artist{}, current.Artists...),
+		Albums:  append([]api.Album{}, current.Albums...),
+		Songs:   append([]api.Song{}, current.Songs...),
+	}
+
+	knownAlbums := make(map[string]bool, len(next.Albums))
+	for _, album := range next.Albums {
+		knownAlbums[album.ID] = true
+	}
+
+	knownArtists := make(map[string]bool, len(next.Artists))
+	for _, artist := range next.Artists {
+		knownArtists[artist.ID] = true
+	}
+
+	const pageSize = 50
+	for offset := 0; ; offset += pageSize {
+		albums, err := api.SubsonicGetAlbumList2("newest", pageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		added := 0
+		for _, album := range albums {
+			if knownAlbums[album.ID] {
+				continue
+			}
+
+			songs, err := api.SubsonicGetAlbumUncached(album.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, song := range songs {
+				if song.ArtistID != "" && !knownArtists[song.ArtistID] {
+					knownArtists[song.ArtistID] = true
+					next.Artists = append(next.Artists, api.Artist{ID: song.ArtistID, Name: song.Artist})
+				}
+			}
+
+			knownAlbums[album.ID] = true
+			next.Albums = append(next.Albums, album)
+			next.Songs = append(next.Songs, songs...)
+			added++
+		}
+
+		// Newest first, so a page without new albums means we caught up
+		if added == 0 || len(albums) < pageSize {
+			break
+		}
+	}
+
+	return next, nil
+}
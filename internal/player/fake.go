@@ -0,0 +1,252 @@
+package player
+
+import (
+	"sync"
+	"time"
+)
+
+const fakeDefaultDuration = 180
+
+// Fake is an in-memory Player for tests. Its clock only moves when Advance
+// is called, so track endings happen deterministically.
+type Fake struct {
+	mu sync.Mutex
+
+	// Song lengths in seconds, fakeDefaultDuration for unknown songs
+	durations map[string]float64
+
+	playlist []playlistEntry
+	position float64
+	paused   bool
+	volume   float64
+	muted    bool
+	speed    float64
+	device   string
+	track    int
+
+	events chan Event
+}
+
+var _ Player = (*Fake)(nil)
+
+func NewFake(durations map[string]float64) *Fake {
+	return &Fake{
+		durations: durations,
+		volume:    100,
+		speed:     1,
+		device:    "auto",
+		events:    make(chan Event, 64),
+	}
+}
+
+// send queues an event for the test to read. Status events are dropped when
+// the test does not keep up; other events wait, as tests rely on every one.
+func (f *Fake) send(event Event) {
+	if event.Type == EventStatus {
+		select {
+		case f.events <- event:
+		default:
+		}
+		return
+	}
+
+	f.events <- event
+}
+
+func (f *Fake) Start() error { return nil }
+
+func (f *Fake) Shutdown() {}
+
+func (f *Fake) Load(songID string, gain float64, startPaused bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.playlist = []playlistEntry{{songID: songID, gain: gain}}
+	f.position = 0
+	f.paused = startPaused
+	f.track++
+	f.send(Event{Type: EventTrackStarted, SongID: songID})
+
+	return nil
+}
+
+func (f *Fake) Prefetch(songID string, gain float64, fade float64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.playlist) == 0 {
+		return nil
+	}
+
+	f.playlist = f.playlist[:1]
+	if songID != "" {
+		f.playlist = append(f.playlist, playlistEntry{songID: songID, gain: gain, fade: fade})
+	}
+
+	// Like mpv reporting its changed playlist
+	f.send(Event{Type: EventStatus})
+
+	return nil
+}
+
+func (f *Fake) Stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.playlist) > 0 {
+		f.send(Event{Type: EventTrackEnded, SongID: f.playlist[0].songID, Reason: EndStop})
+	}
+	f.playlist = nil
+	f.position = 0
+}
+
+func (f *Fake) TogglePause() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.paused = !f.paused
+	f.send(Event{Type: EventStatus})
+}
+
+func (f *Fake) Seek(seconds float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seekTo(f.position + seconds)
+}
+
+func (f *Fake) SeekTo(seconds float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seekTo(seconds)
+}
+
+// seekTo clamps to the current song. Callers hold mu.
+func (f *Fake) seekTo(seconds float64) {
+	if len(f.playlist) == 0 {
+		return
+	}
+
+	f.position = max(0, min(seconds, f.duration(f.playlist[0].songID)))
+	f.send(Event{Type: EventStatus})
+}
+
+// SeekChapter does nothing, fake songs have no chapters.
+func (f *Fake) SeekChapter(delta int) {}
+
+func (f *Fake) SetVolume(volume float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.volume = volume
+	f.send(Event{Type: EventStatus})
+}
+
+func (f *Fake) SetMute(muted bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.muted = muted
+	f.send(Event{Type: EventStatus})
+}
+
+func (f *Fake) SetSpeed(speed float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.speed = speed
+	f.send(Event{Type: EventStatus})
+}
+
+func (f *Fake) SetReplayGain(gain float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.playlist) > 0 {
+		f.playlist[0].gain = gain
+	}
+}
+
+func (f *Fake) SetEqualizer(gains []float64) {}
+
+func (f *Fake) AudioDevices() ([]AudioDevice, error) {
+	return []AudioDevice{{Name: "auto", Description: "Autoselect device"}}, nil
+}
+
+func (f *Fake) SetAudioDevice(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.device = name
+	return nil
+}
+
+func (f *Fake) duration(songID string) float64 {
+	if d, ok := f.durations[songID]; ok {
+		return d
+	}
+	return fakeDefaultDuration
+}
+
+// Advance moves the clock forward, ending songs and starting the prefetched
+// ones as it passes their end.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.paused {
+		return
+	}
+	defer f.send(Event{Type: EventStatus})
+
+	remaining := d.Seconds() * f.speed
+	for len(f.playlist) > 0 && remaining > 0 {
+		current := f.playlist[0].songID
+		left := f.duration(current) - f.position
+
+		if remaining < left {
+			f.position += remaining
+			return
+		}
+
+		remaining -= left
+		f.send(Event{Type: EventTrackEnded, SongID: current, Reason: EndEOF})
+
+		f.playlist = f.playlist[1:]
+		f.position = 0
+		if len(f.playlist) > 0 {
+			f.track++
+			f.send(Event{Type: EventTrackStarted, SongID: f.playlist[0].songID})
+		}
+	}
+}
+
+func (f *Fake) Status() PlayerStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := PlayerStatus{
+		Paused: f.paused,
+		Volume: f.volume,
+		Muted:  f.muted,
+		Speed:  f.speed,
+		Track:  f.track,
+	}
+
+	if len(f.playlist) > 0 {
+		status.SongID = f.playlist[0].songID
+		status.Title = status.SongID
+		status.Current = f.position
+		status.Duration = f.duration(status.SongID)
+	}
+	if len(f.playlist) > 1 {
+		status.NextID = f.playlist[1].songID
+	}
+
+	return status
+}
+
+func (f *Fake) Events() <-chan Event {
+	return f.events
+}
@@ -0,0 +1,185 @@
+package player
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ipcClient speaks mpv's JSON IPC protocol. Replies are matched to commands
+// by request_id, everything else is queued as an event.
+type ipcClient struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan ipcMessage
+
+	// Unbounded, so reading replies never waits for event handling
+	queue  []ipcMessage
+	notify chan struct{}
+	closed chan struct{}
+}
+
+type ipcMessage struct {
+	RequestID int             `json:"request_id"`
+	Error     string          `json:"error"`
+	Data      json.RawMessage `json:"data"`
+
+	Event     string `json:"event"`
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+	FileError string `json:"file_error"`
+	EntryID   int    `json:"playlist_entry_id"`
+}
+
+const ipcTimeout = 5 * time.Second
+
+// dialIPC waits for mpv to create its socket, unless the process exits first.
+func dialIPC(socketPath string, exited <-chan struct{}) (*ipcClient, error) {
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("unix", socketPath); err == nil {
+			break
+		}
+
+		select {
+		case <-exited:
+			return nil, fmt.Errorf("mpv exited on startup")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to mpv: %v", err)
+	}
+
+	c := &ipcClient{
+		conn:    conn,
+		pending: make(map[int]chan ipcMessage),
+		notify:  make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+	go c.readLoop()
+
+	return c, nil
+}
+
+func (c *ipcClient) readLoop() {
+	defer close(c.closed)
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		var msg ipcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		if msg.Event == "" {
+			if reply, ok := c.pending[msg.RequestID]; ok {
+				delete(c.pending, msg.RequestID)
+				reply <- msg
+			}
+		} else {
+			c.queue = append(c.queue, msg)
+		}
+		c.mu.Unlock()
+
+		if msg.Event != "" {
+			select {
+			case c.notify <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// nextEvents blocks until events are queued or the connection is gone.
+func (c *ipcClient) nextEvents() ([]ipcMessage, bool) {
+	for {
+		c.mu.Lock()
+		events := c.queue
+		c.queue = nil
+		c.mu.Unlock()
+
+		if len(events) > 0 {
+			return events, true
+		}
+
+		select {
+		case <-c.notify:
+		case <-c.closed:
+			return nil, false
+		}
+	}
+}
+
+func (c *ipcClient) command(args ...any) (json.RawMessage, error) {
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	reply := make(chan ipcMessage, 1)
+	c.pending[id] = reply
+	c.mu.Unlock()
+
+	data, err := json.Marshal(map[string]any{"command": args, "request_id": id})
+	if err != nil {
+		return nil, err
+	}
+
+	c.writeMu.Lock()
+	_, err = c.conn.Write(append(data, '\n'))
+	c.writeMu.Unlock()
+
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	select {
+	case msg := <-reply:
+		if msg.Error != "success" {
+			return nil, fmt.Errorf("mpv %v: %s", args[0], msg.Error)
+		}
+		return msg.Data, nil
+	case <-c.closed:
+		return nil, fmt.Errorf("mpv connection closed")
+	case <-time.After(ipcTimeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("mpv %v: timed out", args[0])
+	}
+}
+
+func (c *ipcClient) setProperty(name string, value any) error {
+	_, err := c.command("set_property", name, value)
+	return err
+}
+
+func (c *ipcClient) getProperty(name string, value any) error {
+	data, err := c.command("get_property", name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (c *ipcClient) observe(id int, name string) error {
+	_, err := c.command("observe_property", id, name)
+	return err
+}
+
+func (c *ipcClient) close() {
+	_ = c.conn.Close()
+}
@@ -0,0 +1,815 @@
+package player
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+	"github.com/MattiaPun/SubTUI/internal/download"
+	"github.com/MattiaPun/SubTUI/internal/instance"
+)
+
+// MPV plays through an mpv process controlled over its JSON IPC socket. The
+// process is restarted when it dies, resuming the song that was playing.
+type MPV struct {
+	// The running process and its connection, replaced on restart
+	procMu   sync.Mutex
+	client   *ipcClient
+	cmd      *exec.Cmd
+	stopping bool
+
+	// Mirrors mpv's internal playlist: the current song, optionally
+	// followed by the prefetched next one
+	playlistMu sync.Mutex
+	playlist   []playlistEntry
+
+	// Incremented whenever a new playlist entry starts playing
+	track int
+
+	// Volume of the @fade filter, which only exists once needed
+	fadeGain   float64
+	fadeFilter bool
+
+	// Gains of the @equalizer filter, restored after a restart
+	equalizer []float64
+
+	// Set while a restarted mpv opens the song at its old position
+	resuming bool
+
+	// Kept up to date from observed properties, so reading it costs no IPC
+	statusMu sync.Mutex
+	status   PlayerStatus
+
+	events *eventQueue
+}
+
+var _ Player = (*MPV)(nil)
+
+// Properties whose changes mpv sends as events, identified by their index
+var observedProperties = []string{
+	"media-title",
+	"metadata/by-key/artist",
+	"metadata/by-key/album",
+	"time-pos",
+	"duration",
+	"pause",
+	"volume",
+	"mute",
+	"speed",
+	"chapters",
+	"audio-codec-name",
+	"audio-bitrate",
+	"playlist-pos",
+}
+
+// Attempts to bring mpv back before giving up
+const restartAttempts = 3
+
+func NewMPV() *MPV {
+	return &MPV{events: newEventQueue(), fadeGain: 1}
+}
+
+func (p *MPV) Start() error {
+	client, err := p.launch()
+	if err != nil {
+		return err
+	}
+
+	go p.supervise(client)
+
+	return nil
+}
+
+// launch starts an mpv process and connects to its socket.
+func (p *MPV) launch() (*ipcClient, error) {
+	instance.CleanStale()
+	socketPath := instance.MPVSocket()
+
+	args := []string{
+		"--idle",
+		"--no-video",
+		"--gapless-audio=yes",
+		"--prefetch-playlist=yes",
+		"--input-ipc-server=" + socketPath,
+		fmt.Sprintf("--volume-max=%d", api.MaxVolume()),
+	}
+	if !api.PitchCorrection() {
+		args = append(args, "--audio-pitch-correction=no")
+	}
+	if volume := api.AppConfig.Player.Volume; volume != nil {
+		args = append(args, fmt.Sprintf("--volume=%d", *volume))
+	}
+	if device := api.AppConfig.Player.AudioDevice; device != "" {
+		args = append(args, "--audio-device="+device)
+	}
+
+	log.Printf("player: starting mpv %v", args)
+
+	output := &tailWriter{}
+	cmd := exec.Command("mpv", args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start mpv: %v", err)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		log.Printf("player: mpv exited: %v %s", err, output.lastLine())
+		close(exited)
+	}()
+
+	client, err := dialIPC(socketPath, exited)
+	if err != nil {
+		_ = cmd.Process.Kill()
+		<-exited
+		if line := output.lastLine(); line != "" {
+			return nil, fmt.Errorf("%v: %s", err, line)
+		}
+		return nil, err
+	}
+
+	for id, name := range observedProperties {
+		if err := client.observe(id, name); err != nil {
+			client.close()
+			_ = cmd.Process.Kill()
+			return nil, err
+		}
+	}
+
+	p.procMu.Lock()
+	defer p.procMu.Unlock()
+
+	if p.stopping {
+		client.close()
+		_ = cmd.Process.Kill()
+		return nil, fmt.Errorf("player shut down")
+	}
+	p.client = client
+	p.cmd = cmd
+
+	return client, nil
+}
+
+// ipc returns the connection to the current mpv, nil before Start. During a
+// restart it is the old one, whose commands fail right away.
+func (p *MPV) ipc() *ipcClient {
+	p.procMu.Lock()
+	defer p.procMu.Unlock()
+
+	return p.client
+}
+
+func (p *MPV) Shutdown() {
+	p.procMu.Lock()
+	defer p.procMu.Unlock()
+
+	p.stopping = true
+	if p.client != nil {
+		p.client.close()
+	}
+	if p.cmd != nil {
+		_ = p.cmd.Process.Signal(syscall.SIGTERM)
+	}
+}
+
+// supervise handles the events of each mpv in turn, restarting it whenever
+// the connection is lost, until Shutdown.
+func (p *MPV) supervise(client *ipcClient) {
+	for {
+		p.handleEvents(client)
+
+		p.procMu.Lock()
+		stopping := p.stopping
+		p.procMu.Unlock()
+		if stopping {
+			return
+		}
+
+		next, err := p.restart()
+		if err != nil {
+			log.Printf("player: giving up on mpv: %v", err)
+			p.events.send(Event{Type: EventPlayerFailed, Err: err.Error()})
+			return
+		}
+		client = next
+	}
+}
+
+// restart replaces a dead mpv and resumes the current song at the position
+// and pause state it had.
+func (p *MPV) restart() (*ipcClient, error) {
+	p.procMu.Lock()
+	if p.cmd != nil {
+		_ = p.cmd.Process.Kill()
+	}
+	p.procMu.Unlock()
+
+	p.statusMu.Lock()
+	status := p.status
+	p.statusMu.Unlock()
+
+	p.playlistMu.Lock()
+	var current []playlistEntry
+	if len(p.playlist) > 0 {
+		current = p.playlist[:1]
+	}
+	p.playlist = nil
+	p.fadeGain = 1
+	p.fadeFilter = false
+	p.playlistMu.Unlock()
+
+	log.Printf("player: lost mpv, restarting")
+	p.events.send(Event{Type: EventPlayerRestarted})
+
+	var err error
+	for attempt := 1; attempt <= restartAttempts; attempt++ {
+		time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+
+		var client *ipcClient
+		if client, err = p.launch(); err != nil {
+			log.Printf("player: restart %d failed: %v", attempt, err)
+			continue
+		}
+
+		p.restore(client, status, current)
+		return client, nil
+	}
+
+	return nil, err
+}
+
+// restore applies the settings of the previous mpv to a new one and reopens
+// its song, using mpv's start option until the file is loaded.
+func (p *MPV) restore(client *ipcClient, status PlayerStatus, current []playlistEntry) {
+	// Zero until the old mpv reported its properties
+	if status.Speed > 0 {
+		_ = client.setProperty("volume", status.Volume)
+		_ = client.setProperty("mute", status.Muted)
+		_ = client.setProperty("speed", status.Speed)
+	}
+
+	p.playlistMu.Lock()
+	defer p.playlistMu.Unlock()
+
+	if p.equalizer != nil {
+		p.applyEqualizer(p.equalizer)
+	}
+
+	if len(current) == 0 {
+		return
+	}
+
+	p.applyNetworkOptions()
+	_ = client.setProperty("pause", status.Paused)
+	if status.Current > 0 {
+		_ = client.setProperty("start", fmt.Sprintf("%.3f", status.Current))
+		p.resuming = true
+	}
+
+	url, err := songURL(current[0].songID)
+	if err != nil {
+		log.Printf("player: could not resume %s: %v", current[0].songID, err)
+		return
+	}
+	if _, err := client.command("loadfile", url, "replace"); err != nil {
+		log.Printf("player: could not resume %s: %v", current[0].songID, err)
+		return
+	}
+	p.playlist = []playlistEntry{{songID: current[0].songID, gain: current[0].gain, id: p.lastEntryID()}}
+	p.applyReplayGain(current[0].gain)
+}
+
+// handleEvents turns mpv events into status updates and player events until
+// the connection closes.
+func (p *MPV) handleEvents(client *ipcClient) {
+	for {
+		messages, ok := client.nextEvents()
+		if !ok {
+			return
+		}
+
+		changed := false
+		fade := false
+		for _, msg := range messages {
+			switch msg.Event {
+			case "property-change":
+				if p.updateStatus(msg) {
+					changed = true
+				}
+				fade = true
+			case "end-file":
+				p.endFile(msg)
+				changed = true
+			case "file-loaded":
+				// The start option would apply to every later file too
+				if p.resuming {
+					_ = client.setProperty("start", "none")
+					p.resuming = false
+				}
+			}
+		}
+
+		if fade {
+			p.updateFade()
+		}
+
+		if changed {
+			p.events.send(Event{Type: EventStatus})
+		}
+	}
+}
+
+// updateStatus applies an observed property and reports whether anything
+// visible changed. The position only counts once per second.
+func (p *MPV) updateStatus(msg ipcMessage) bool {
+	if msg.ID < 0 || msg.ID >= len(observedProperties) {
+		return false
+	}
+
+	if observedProperties[msg.ID] == "playlist-pos" {
+		var pos int
+		if json.Unmarshal(msg.Data, &pos) == nil {
+			p.syncPlaylist(pos)
+		}
+		return true
+	}
+
+	var text string
+	var number float64
+	var flag bool
+	_ = json.Unmarshal(msg.Data, &text)
+	_ = json.Unmarshal(msg.Data, &number)
+	_ = json.Unmarshal(msg.Data, &flag)
+
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+
+	s := &p.status
+	switch observedProperties[msg.ID] {
+	case "media-title":
+		s.Title = text
+	case "metadata/by-key/artist":
+		s.Artist = text
+	case "metadata/by-key/album":
+		s.Album = text
+	case "time-pos":
+		previous := s.Current
+		s.Current = number
+		return int(previous) != int(number)
+	case "duration":
+		s.Duration = number
+	case "pause":
+		s.Paused = flag
+	case "volume":
+		s.Volume = number
+	case "mute":
+		s.Muted = flag
+	case "speed":
+		s.Speed = number
+	case "chapters":
+		s.Chapters = int(number)
+	case "audio-codec-name":
+		s.Codec = text
+	case "audio-bitrate":
+		s.Bitrate = number
+	}
+
+	return true
+}
+
+func (p *MPV) endFile(msg ipcMessage) {
+	p.playlistMu.Lock()
+	defer p.playlistMu.Unlock()
+
+	// Entries replaced by Load are already gone from the mirror
+	songID := ""
+	for _, entry := range p.playlist {
+		if entry.id == msg.EntryID {
+			songID = entry.songID
+		}
+	}
+	if songID == "" {
+		return
+	}
+
+	if msg.Reason == EndError {
+		log.Printf("player: %s failed: %s", songID, msg.FileError)
+	}
+
+	// Nothing prefetched, mpv goes idle
+	if (msg.Reason == EndEOF || msg.Reason == EndError) && len(p.playlist) == 1 {
+		p.playlist = nil
+	}
+
+	p.events.send(Event{Type: EventTrackEnded, SongID: songID, Reason: msg.Reason, Err: msg.FileError})
+}
+
+func (p *MPV) Load(songID string, gain float64, startPaused bool) error {
+	client := p.ipc()
+	if client == nil {
+		return fmt.Errorf("player not initialized")
+	}
+
+	p.playlistMu.Lock()
+	defer p.playlistMu.Unlock()
+
+	url, err := songURL(songID)
+	if err != nil {
+		return err
+	}
+
+	p.applyNetworkOptions()
+
+	if _, err := client.command("loadfile", url, "replace"); err != nil {
+		return err
+	}
+	p.playlist = []playlistEntry{{songID: songID, gain: gain, id: p.lastEntryID()}}
+	p.track++
+	p.applyReplayGain(gain)
+	p.events.send(Event{Type: EventTrackStarted, SongID: songID})
+
+	// Off the lock, a slow server must not hold up playback
+	go func() { _ = api.SubsonicScrobble(songID, false, time.Now()) }()
+
+	_ = client.setProperty("pause", startPaused)
+
+	return nil
+}
+
+// applyNetworkOptions passes the TLS, proxy and header settings of the
+// current profile on to mpv. Other proxies than HTTP ones are refused by
+// songURL, as mpv does not support them.
+func (p *MPV) applyNetworkOptions() {
+	client := p.ipc()
+
+	network := api.CurrentProfile().Network
+
+	// Set every time, so verification is back on after leaving an insecure profile
+	_ = client.setProperty("tls-verify", !network.InsecureSkipVerify)
+	_ = client.setProperty("tls-ca-file", network.CAFile)
+	_ = client.setProperty("tls-cert-file", network.ClientCert)
+	_ = client.setProperty("tls-key-file", network.ClientKey)
+
+	proxy := ""
+	if strings.HasPrefix(network.Proxy, "http://") {
+		proxy = network.Proxy
+	}
+	_ = client.setProperty("http-proxy", proxy)
+
+	headers := []string{}
+	for name, value := range network.Headers {
+		headers = append(headers, name+": "+value)
+	}
+	_ = client.setProperty("http-header-fields", headers)
+}
+
+// songURL returns where mpv plays a song from. Streaming is refused when
+// mpv could not go through the configured proxy, rather than going around it.
+func songURL(songID string) (string, error) {
+	if path, ok := download.LocalPath(songID); ok {
+		return path, nil
+	}
+
+	if proxy := api.CurrentProfile().Network.Proxy; proxy != "" && !strings.HasPrefix(proxy, "http://") {
+		return "", fmt.Errorf("mpv can only stream through http:// proxies, not %s", proxy)
+	}
+
+	return api.SubsonicStream(songID), nil
+}
+
+func (p *MPV) Prefetch(songID string, gain float64, fade float64) error {
+	client := p.ipc()
+	if client == nil {
+		return fmt.Errorf("player not initialized")
+	}
+
+	p.playlistMu.Lock()
+	defer p.playlistMu.Unlock()
+
+	if len(p.playlist) == 0 {
+		return nil
+	}
+
+	if len(p.playlist) > 1 {
+		if _, err := client.command("playlist-clear"); err != nil {
+			return err
+		}
+		p.playlist = p.playlist[:1]
+	}
+
+	if songID == "" {
+		return nil
+	}
+
+	url, err := songURL(songID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.command("loadfile", url, "append"); err != nil {
+		return err
+	}
+	p.playlist = append(p.playlist, playlistEntry{songID: songID, gain: gain, id: p.lastEntryID(), fade: fade})
+
+	return nil
+}
+
+// lastEntryID returns the playlist_entry_id of the file loaded last.
+func (p *MPV) lastEntryID() int {
+	client := p.ipc()
+
+	var entries []struct {
+		ID int `json:"id"`
+	}
+	if err := client.getProperty("playlist", &entries); err != nil || len(entries) == 0 {
+		return 0
+	}
+
+	return entries[len(entries)-1].ID
+}
+
+// syncPlaylist drops entries mpv moved past once it reaches position pos.
+func (p *MPV) syncPlaylist(pos int) {
+	client := p.ipc()
+
+	p.playlistMu.Lock()
+	defer p.playlistMu.Unlock()
+
+	if pos <= 0 || pos >= len(p.playlist) {
+		return
+	}
+
+	_, _ = client.command("playlist-clear")
+
+	p.playlist = p.playlist[pos : pos+1]
+	p.track++
+
+	// The old position would count towards the fade of the new song
+	p.statusMu.Lock()
+	p.status.Current = 0
+	p.status.Duration = 0
+	p.statusMu.Unlock()
+
+	p.applyReplayGain(p.playlist[0].gain)
+	p.events.send(Event{Type: EventTrackStarted, SongID: p.playlist[0].songID})
+
+	go func(songID string) { _ = api.SubsonicScrobble(songID, false, time.Now()) }(p.playlist[0].songID)
+}
+
+// updateFade turns the volume down over the end of a song that fades into the
+// prefetched one, and back up over the start of that song.
+func (p *MPV) updateFade() {
+	p.statusMu.Lock()
+	current, duration := p.status.Current, p.status.Duration
+	p.statusMu.Unlock()
+
+	p.playlistMu.Lock()
+	defer p.playlistMu.Unlock()
+
+	gain := 1.0
+	if len(p.playlist) > 1 && p.playlist[1].fade > 0 && duration > 0 {
+		gain = min(gain, (duration-current)/p.playlist[1].fade)
+	}
+	if len(p.playlist) > 0 && p.playlist[0].fade > 0 {
+		gain = min(gain, current/p.playlist[0].fade)
+	}
+	gain = math.Round(max(gain, 0)*50) / 50
+
+	if gain == p.fadeGain {
+		return
+	}
+	p.fadeGain = gain
+
+	client := p.ipc()
+
+	if !p.fadeFilter {
+		if _, err := client.command("af", "add", fmt.Sprintf("@fade:lavfi=[volume=%g]", gain)); err == nil {
+			p.fadeFilter = true
+		}
+		return
+	}
+	_, _ = client.command("af-command", "fade", "volume", fmt.Sprint(gain))
+}
+
+func (p *MPV) Stop() {
+	client := p.ipc()
+	if client == nil {
+		return
+	}
+
+	p.playlistMu.Lock()
+	defer p.playlistMu.Unlock()
+
+	_, _ = client.command("stop")
+	p.playlist = nil
+}
+
+func (p *MPV) TogglePause() {
+	client := p.ipc()
+	if client == nil {
+		return
+	}
+
+	_, _ = client.command("cycle", "pause")
+}
+
+func (p *MPV) Seek(seconds float64) {
+	client := p.ipc()
+	if client == nil {
+		return
+	}
+
+	_, _ = client.command("seek", seconds, "relative")
+}
+
+func (p *MPV) SeekTo(seconds float64) {
+	client := p.ipc()
+	if client == nil {
+		return
+	}
+
+	_, _ = client.command("seek", seconds, "absolute")
+}
+
+// SeekChapter moves delta chapters forward or back, if the file has any.
+func (p *MPV) SeekChapter(delta int) {
+	client := p.ipc()
+	if client == nil {
+		return
+	}
+
+	_, _ = client.command("add", "chapter", delta)
+}
+
+func (p *MPV) SetVolume(volume float64) {
+	client := p.ipc()
+	if client == nil {
+		return
+	}
+
+	_ = client.setProperty("volume", volume)
+}
+
+func (p *MPV) SetMute(muted bool) {
+	client := p.ipc()
+	if client == nil {
+		return
+	}
+
+	_ = client.setProperty("mute", muted)
+}
+
+func (p *MPV) SetSpeed(speed float64) {
+	client := p.ipc()
+	if client == nil {
+		return
+	}
+
+	_ = client.setProperty("speed", speed)
+}
+
+// SetReplayGain changes the gain of the song that is playing right now.
+func (p *MPV) SetReplayGain(gain float64) {
+	client := p.ipc()
+	if client == nil {
+		return
+	}
+
+	p.playlistMu.Lock()
+	defer p.playlistMu.Unlock()
+
+	if len(p.playlist) > 0 {
+		p.playlist[0].gain = gain
+	}
+	p.applyReplayGain(gain)
+}
+
+// applyReplayGain replaces the labelled volume filter in mpv's audio chain.
+// Callers hold playlistMu.
+func (p *MPV) applyReplayGain(gain float64) {
+	client := p.ipc()
+
+	if gain == 0 {
+		_, _ = client.command("af", "remove", "@replaygain")
+		return
+	}
+
+	_, _ = client.command("af", "add", fmt.Sprintf("@replaygain:lavfi=[volume=%.2fdB]", gain))
+}
+
+// SetEqualizer replaces the labelled equalizer filter, or removes it when
+// all bands are flat.
+func (p *MPV) SetEqualizer(gains []float64) {
+	if p.ipc() == nil {
+		return
+	}
+
+	p.playlistMu.Lock()
+	defer p.playlistMu.Unlock()
+
+	p.equalizer = gains
+	p.applyEqualizer(gains)
+}
+
+// applyEqualizer replaces the labelled filter chain. Callers hold playlistMu.
+func (p *MPV) applyEqualizer(gains []float64) {
+	client := p.ipc()
+
+	bands := []string{}
+	for i, gain := range gains {
+		if gain != 0 && i < len(api.EqualizerBands) {
+			bands = append(bands, fmt.Sprintf("equalizer=f=%d:t=o:w=1:g=%g", api.EqualizerBands[i], gain))
+		}
+	}
+
+	if len(bands) == 0 {
+		_, _ = client.command("af", "remove", "@equalizer")
+		return
+	}
+
+	_, _ = client.command("af", "add", "@equalizer:lavfi=["+strings.Join(bands, ",")+"]")
+}
+
+func (p *MPV) AudioDevices() ([]AudioDevice, error) {
+	client := p.ipc()
+	if client == nil {
+		return nil, fmt.Errorf("player not initialized")
+	}
+
+	var devices []AudioDevice
+	if err := client.getProperty("audio-device-list", &devices); err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+// SetAudioDevice moves playback to another output right away.
+func (p *MPV) SetAudioDevice(name string) error {
+	client := p.ipc()
+	if client == nil {
+		return fmt.Errorf("player not initialized")
+	}
+
+	return client.setProperty("audio-device", name)
+}
+
+func (p *MPV) Status() PlayerStatus {
+	p.statusMu.Lock()
+	status := p.status
+	p.statusMu.Unlock()
+
+	p.playlistMu.Lock()
+	defer p.playlistMu.Unlock()
+
+	if len(p.playlist) > 0 {
+		status.SongID = p.playlist[0].songID
+	}
+	if len(p.playlist) > 1 {
+		status.NextID = p.playlist[1].songID
+	}
+	status.Track = p.track
+
+	return status
+}
+
+func (p *MPV) Events() <-chan Event {
+	return p.events.out
+}
+
+// tailWriter keeps the end of mpv's output, to tell why it exited.
+type tailWriter struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+const tailSize = 4096
+
+func (w *tailWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.data = append(w.data, b...)
+	if len(w.data) > tailSize {
+		w.data = w.data[len(w.data)-tailSize:]
+	}
+
+	return len(b), nil
+}
+
+func (w *tailWriter) lastLine() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	lines := strings.Split(strings.TrimSpace(string(w.data)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
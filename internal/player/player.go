@@ -1,21 +1,39 @@
 package player
 
-import (
-	"fmt"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"syscall"
-	"time"
-
-	"github.com/MattiaPun/SubTUI/internal/api"
-	"github.com/gdrens/mpv"
-)
-
-var (
-	mpvClient *mpv.Client
-	mpvCmd    *exec.Cmd
-)
+import "sync"
+
+// Player is a playback backend. Songs are identified by their Subsonic ID
+// and gains are ReplayGain adjustments in dB.
+type Player interface {
+	Start() error
+	Shutdown()
+
+	// Load replaces whatever is playing
+	Load(songID string, gain float64, startPaused bool) error
+	// Prefetch makes songID the entry played after the current one, so the
+	// transition is gapless. An empty songID removes any prefetched entry.
+	// With a fade in seconds, the current song fades out and songID fades
+	// in. mpv plays one file at a time, so the two do not overlap.
+	Prefetch(songID string, gain float64, fade float64) error
+	Stop()
+
+	TogglePause()
+	Seek(seconds float64)
+	SeekTo(seconds float64)
+	SeekChapter(delta int)
+	SetVolume(volume float64)
+	SetMute(muted bool)
+	SetSpeed(speed float64)
+	SetReplayGain(gain float64)
+	// SetEqualizer applies a gain in dB to each of api.EqualizerBands
+	SetEqualizer(gains []float64)
+
+	AudioDevices() ([]AudioDevice, error)
+	SetAudioDevice(name string) error
+
+	Status() PlayerStatus
+	Events() <-chan Event
+}
 
 type PlayerStatus struct {
 	Title    string
@@ -25,106 +43,93 @@ type PlayerStatus struct {
 	Duration float64
 	Paused   bool
 	Volume   float64
+	Muted    bool
+	Speed    float64
+	Chapters int
+	Codec    string
+	Bitrate  float64
+	SongID   string
+	NextID   string
+	Track    int
 }
 
-func InitPlayer() error {
-	socketPath := filepath.Join(os.TempDir(), fmt.Sprintf("subtui_mpv_socket_%d", os.Getuid()))
-
-	_ = exec.Command("pkill", "-f", socketPath).Run()
-	time.Sleep(200 * time.Millisecond)
-
-	args := []string{
-		"--idle",
-		"--no-video",
-		"--input-ipc-server=" + socketPath,
-	}
-
-	mpvCmd = exec.Command("mpv", args...)
-	if err := mpvCmd.Start(); err != nil {
-		return fmt.Errorf("failed to start mpv: %v", err)
-	}
-
-	maxRetries := 10
-	for i := 0; i < maxRetries; i++ {
-		if _, err := os.Stat(socketPath); err == nil {
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
-	}
-
-	ipcc := mpv.NewIPCClient(socketPath)
-	client := mpv.NewClient(ipcc)
-	mpvClient = client
-
-	return nil
-}
-
-func ShutdownPlayer() {
-	if mpvCmd != nil {
-		_ = mpvCmd.Process.Signal(syscall.SIGTERM)
-	}
+// AudioDevice is an output as listed by mpv, where "auto" is the default one.
+type AudioDevice struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
 }
 
-func PlaySong(songID string, startPaused bool) error {
-	if mpvClient == nil {
-		return fmt.Errorf("player not initialized")
-	}
-
-	url := api.SubsonicStream(songID)
-	if err := mpvClient.LoadFile(url, mpv.LoadFileModeReplace); err != nil {
-		return err
-	}
-
-	api.SubsonicScrobble(songID, false)
+const (
+	EventStatus = iota // Something in Status() changed
+	EventTrackStarted
+	EventTrackEnded
+	EventPlayerRestarted // The backend died and was restarted
+	EventPlayerFailed    // The backend died for good, Err tells why
+)
 
-	_ = mpvClient.SetProperty("pause", startPaused)
+// Reasons a track ended
+const (
+	EndEOF   = "eof"
+	EndError = "error"
+	EndStop  = "stop"
+)
 
-	return nil
+type Event struct {
+	Type   int
+	SongID string
+	Reason string
+	Err    string // Why a track ended with EndError, or the backend failed
 }
 
-func TogglePause() {
-	if mpvClient == nil {
-		return
-	}
-
-	status := mpvClient.IsPause()
-	_ = mpvClient.SetProperty("pause", !status)
+type playlistEntry struct {
+	songID string
+	gain   float64
+	id     int     // mpv's playlist_entry_id
+	fade   float64 // Seconds of fading out into this entry and in
 }
 
-func RestartSong() {
-	_ = mpvClient.Seek(-int(mpvClient.Position()))
-
+// eventQueue hands events to a reader without blocking playback on it.
+// Lifecycle events are all kept however far the reader falls behind; status
+// events only say that something changed, so consecutive ones are merged.
+type eventQueue struct {
+	mu    sync.Mutex
+	queue []Event
+	wake  chan struct{}
+	out   chan Event
 }
 
-func Back10Seconds() {
-	_ = mpvClient.Seek(-10)
+func newEventQueue() *eventQueue {
+	q := &eventQueue{wake: make(chan struct{}, 1), out: make(chan Event)}
+	go q.forward()
+	return q
 }
 
-func Forward10Seconds() {
-	_ = mpvClient.Seek(+10)
-}
+func (q *eventQueue) send(event Event) {
+	q.mu.Lock()
+	if n := len(q.queue); event.Type != EventStatus || n == 0 || q.queue[n-1].Type != EventStatus {
+		q.queue = append(q.queue, event)
+	}
+	q.mu.Unlock()
 
-func GetPlayerStatus() PlayerStatus {
-	if mpvClient == nil {
-		return PlayerStatus{}
+	select {
+	case q.wake <- struct{}{}:
+	default:
 	}
+}
 
-	title := mpvClient.GetProperty("media-title")
-	artist := mpvClient.GetProperty("metadata/by-key/artist")
-	album := mpvClient.GetProperty("metadata/by-key/album")
-
-	pos := mpvClient.Position()
-	dur := mpvClient.Duration()
-	paused := mpvClient.IsPause()
-	vol, _ := mpvClient.GetFloatProperty("volume")
-
-	return PlayerStatus{
-		Title:    fmt.Sprintf("%v", title),
-		Artist:   fmt.Sprintf("%v", artist),
-		Album:    fmt.Sprintf("%v", album),
-		Current:  pos,
-		Duration: dur,
-		Paused:   paused,
-		Volume:   vol,
+func (q *eventQueue) forward() {
+	for range q.wake {
+		for {
+			q.mu.Lock()
+			if len(q.queue) == 0 {
+				q.mu.Unlock()
+				break
+			}
+			event := q.queue[0]
+			q.queue = q.queue[1:]
+			q.mu.Unlock()
+
+			q.out <- event
+		}
 	}
 }
@@ -0,0 +1,36 @@
+package player
+
+import (
+	"testing"
+)
+
+func TestEventQueueKeepsLifecycleEvents(t *testing.T) {
+	q := newEventQueue()
+
+	// Far more than any channel buffer, with nobody reading yet
+	const songs = 1000
+	for i := 0; i < songs; i++ {
+		q.send(Event{Type: EventStatus})
+		q.send(Event{Type: EventStatus})
+		q.send(Event{Type: EventTrackStarted, SongID: string(rune('a' + i%26))})
+	}
+
+	statuses := 0
+	for i := 0; i < songs; {
+		event := <-q.out
+		if event.Type == EventStatus {
+			statuses++
+			continue
+		}
+
+		if want := string(rune('a' + i%26)); event.SongID != want {
+			t.Fatalf("event %d is for %q, want %q", i, event.SongID, want)
+		}
+		i++
+	}
+
+	// The reader only started after everything was sent
+	if statuses > songs+1 {
+		t.Fatalf("%d status events for %d songs", statuses, songs)
+	}
+}
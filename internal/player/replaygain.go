@@ -0,0 +1,36 @@
+package player
+
+import (
+	"math"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+)
+
+// ReplayGain returns the gain in dB for a song, using the album gain if album
+// is set. Pre-amp and clipping prevention from the config are applied.
+func ReplayGain(song api.Song, album bool) float64 {
+	cfg := api.AppConfig.ReplayGain
+	if cfg.Mode == "" || cfg.Mode == "off" || song.ReplayGain == nil {
+		return 0
+	}
+
+	rg := song.ReplayGain
+	gain, peak := rg.TrackGain, rg.TrackPeak
+	if album && rg.AlbumGain != 0 {
+		gain, peak = rg.AlbumGain, rg.AlbumPeak
+	}
+
+	if gain == 0 {
+		gain = rg.FallbackGain
+	}
+
+	gain += cfg.PreAmp
+
+	if cfg.PreventClipping && peak > 0 {
+		if limit := -20 * math.Log10(peak); gain > limit {
+			gain = limit
+		}
+	}
+
+	return gain
+}
@@ -0,0 +1,170 @@
+package scrobble
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+)
+
+// Entry is a finished play waiting for the server to acknowledge it.
+type Entry struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"` // When the song started playing
+}
+
+// same reports whether two entries are the same play. Times read back from
+// the journal lose their monotonic reading and location, so == would not do.
+func (e Entry) same(other Entry) bool {
+	return e.ID == other.ID && e.Time.Equal(other.Time)
+}
+
+var (
+	mu       sync.Mutex
+	pending  []Entry
+	loaded   string // ServerKey the journal in memory belongs to
+	flushing bool
+)
+
+func journalPath() (string, error) {
+	dir, err := api.StateDir()
+	if err != nil {
+		return "", err
+	}
+
+	// One journal per server and user
+	return filepath.Join(dir, fmt.Sprintf("scrobbles-%s.json", api.ServerKey())), nil
+}
+
+// load reads the journal of the current server, if another one is in
+// memory. Callers hold mu.
+func load() {
+	key := api.ServerKey()
+	if key == loaded {
+		return
+	}
+
+	loaded = key
+	pending = nil
+
+	path, err := journalPath()
+	if err != nil {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &pending); err != nil {
+		log.Printf("scrobble: could not read %s: %v", path, err)
+	}
+}
+
+// save writes the journal atomically. Callers hold mu.
+func save() error {
+	path, err := journalPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Submit journals a play before sending it, so it survives being offline or
+// quitting. The same song and start time is only kept once.
+func Submit(id string, started time.Time) {
+	mu.Lock()
+	load()
+
+	entry := Entry{ID: id, Time: started.UTC().Truncate(time.Millisecond)}
+	if !slices.ContainsFunc(pending, entry.same) {
+		pending = append(pending, entry)
+	}
+	err := save()
+	mu.Unlock()
+
+	if err != nil {
+		log.Printf("scrobble: could not save journal: %v", err)
+	}
+
+	if err := Flush(); err != nil {
+		log.Printf("scrobble: %v", err)
+	}
+}
+
+// Flush sends pending plays oldest first. Plays of songs the server does not
+// have are dropped, as retrying would not change its mind. Any other error,
+// like failed authentication, stops the flush and keeps the rest for later.
+func Flush() error {
+	mu.Lock()
+	if flushing {
+		mu.Unlock()
+		return nil
+	}
+	flushing = true
+	load()
+	key := loaded
+	batch := slices.Clone(pending)
+	mu.Unlock()
+
+	defer func() {
+		mu.Lock()
+		flushing = false
+		mu.Unlock()
+	}()
+
+	for _, entry := range batch {
+		if err := api.SubsonicScrobble(entry.ID, true, entry.Time); err != nil {
+			if !errors.Is(err, api.ErrNotFound) {
+				return fmt.Errorf("keeping plays for later: %v", err)
+			}
+			log.Printf("scrobble: dropping %s: %v", entry.ID, err)
+		}
+
+		var err error
+		mu.Lock()
+		// The profile may have changed meanwhile
+		if loaded == key {
+			pending = slices.DeleteFunc(pending, entry.same)
+			err = save()
+		}
+		mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Pending is the number of plays the server has not acknowledged yet.
+func Pending() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	load()
+	return len(pending)
+}
@@ -0,0 +1,96 @@
+package scrobble
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+)
+
+// newServer answers scrobbles by song ID: "gone" is a song the server does
+// not have, "denied" fails at the HTTP level without a Subsonic answer and
+// "locked" fails authentication. Anything else is accepted.
+func newServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("id") {
+		case "gone":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"subsonic-response":{"status":"failed","error":{"code":70,"message":"Song not found"}}}`)
+		case "denied":
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		case "locked":
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = io.WriteString(w, `{"subsonic-response":{"status":"failed","error":{"code":40,"message":"Wrong username or password"}}}`)
+		default:
+			_, _ = io.WriteString(w, `{"subsonic-response":{"status":"ok"}}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestFlush(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     []string
+		kept    []string
+		wantErr bool
+	}{
+		{"all accepted", []string{"a", "b"}, []string{}, false},
+		{"unknown songs are dropped", []string{"a", "gone", "b"}, []string{}, false},
+		{"HTTP errors keep the rest", []string{"a", "denied", "b"}, []string{"denied", "b"}, true},
+		{"failed login keeps the rest", []string{"gone", "locked", "b"}, []string{"locked", "b"}, true},
+	}
+
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_STATE_HOME", t.TempDir())
+			api.AppConfig = api.Config{
+				Profiles:      []api.Profile{{Name: "test", URL: newServer(t).URL, Username: "user", Password: "secret"}},
+				ActiveProfile: "test",
+			}
+			api.AppConfig.Secrets.Backend = api.SecretsPlain
+
+			started := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+			mu.Lock()
+			loaded = api.ServerKey()
+			pending = nil
+			for i, id := range tt.ids {
+				pending = append(pending, Entry{ID: id, Time: started.Add(time.Duration(i) * time.Minute)})
+			}
+			err := save()
+			mu.Unlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Flush(); (err != nil) != tt.wantErr {
+				t.Fatalf("flush error = %v, want error %v", err, tt.wantErr)
+			}
+
+			// Read the journal back from disk
+			mu.Lock()
+			loaded = ""
+			load()
+			kept := []string{}
+			for _, entry := range pending {
+				kept = append(kept, entry.ID)
+			}
+			mu.Unlock()
+
+			if !slices.Equal(kept, tt.kept) {
+				t.Fatalf("kept %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+	"github.com/MattiaPun/SubTUI/internal/library"
+)
+
+type songColumn struct {
+	header string
+	value  func(api.Song) string
+	field  int // Library search field to highlight, -1 for none
+}
+
+var songColumns = map[string]songColumn{
+	"title":       {"TITLE", func(s api.Song) string { return s.Title }, library.FieldTitle},
+	"artist":      {"ARTIST", func(s api.Song) string { return s.Artist }, library.FieldArtist},
+	"album":       {"ALBUM", func(s api.Song) string { return s.Album }, library.FieldAlbum},
+	"albumartist": {"ALBUM ARTIST", func(s api.Song) string { return s.AlbumArtist() }, -1},
+	"duration":    {"TIME", func(s api.Song) string { return formatDuration(s.Duration) }, -1},
+	"track":       {"#", func(s api.Song) string { return formatInt(int64(s.Track)) }, -1},
+	"disc":        {"DISC", func(s api.Song) string { return formatInt(int64(s.DiscNumber)) }, -1},
+	"year":        {"YEAR", func(s api.Song) string { return formatInt(int64(s.Year)) }, -1},
+	"genre":       {"GENRE", func(s api.Song) string { return s.Genre }, -1},
+	"bitrate":     {"KBPS", func(s api.Song) string { return formatInt(int64(s.BitRate)) }, -1},
+	"format":      {"FORMAT", func(s api.Song) string { return s.Suffix }, -1},
+	"contenttype": {"TYPE", func(s api.Song) string { return s.ContentType }, -1},
+	"size":        {"SIZE", func(s api.Song) string { return formatSize(s.Size) }, -1},
+	"path":        {"PATH", func(s api.Song) string { return s.Path }, -1},
+	"playcount":   {"PLAYS", func(s api.Song) string { return formatInt(s.PlayCount) }, -1},
+	"played":      {"PLAYED", func(s api.Song) string { return formatDate(s.Played) }, -1},
+	"created":     {"ADDED", func(s api.Song) string { return formatDate(s.Created) }, -1},
+	"bpm":         {"BPM", func(s api.Song) string { return formatInt(int64(s.BPM)) }, -1},
+}
+
+// layoutColumns drops unknown columns and turns the configured percentages
+// into widths. Columns without a width share the remaining space.
+func layoutColumns(columns []api.Column, availableWidth int) ([]songColumn, []int) {
+	known := []songColumn{}
+	percents := []int{}
+	for _, c := range columns {
+		if col, ok := songColumns[strings.ToLower(c.Name)]; ok {
+			known = append(known, col)
+			percents = append(percents, c.Width)
+		}
+	}
+
+	widths := make([]int, len(known))
+	flexible := 0
+	total := 0
+	for _, percent := range percents {
+		if percent <= 0 {
+			flexible++
+		} else {
+			total += percent
+		}
+	}
+
+	// Percentages adding up to more than 100, or leaving no room for the
+	// separators and flexible columns, are scaled down to fit
+	budget := max(availableWidth-(len(known)-1)-flexible, 0)
+	scaled := availableWidth*total/100 > budget
+
+	used := len(known) - 1 // Separators
+	for i, percent := range percents {
+		if percent <= 0 {
+			continue
+		}
+		if scaled {
+			widths[i] = budget * percent / total
+		} else {
+			widths[i] = availableWidth * percent / 100
+		}
+		used += widths[i]
+	}
+
+	if flexible > 0 {
+		share := (availableWidth - used) / flexible
+		if share < 1 {
+			share = 1
+		}
+		for i, percent := range percents {
+			if percent <= 0 {
+				widths[i] = share
+			}
+		}
+	}
+
+	return known, widths
+}
+
+func formatInt(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func formatSize(bytes int64) string {
+	if bytes == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f MB", float64(bytes)/1024/1024)
+}
+
+// Keeps the date part of an ISO 8601 timestamp
+func formatDate(timestamp string) string {
+	if len(timestamp) >= 10 {
+		return timestamp[:10]
+	}
+	return timestamp
+}
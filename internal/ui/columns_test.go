@@ -0,0 +1,85 @@
+package ui
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+)
+
+func TestLayoutColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns []api.Column
+		width   int
+		headers []string
+		widths  []int
+	}{
+		{
+			name:    "percentages",
+			columns: []api.Column{{Name: "title", Width: 50}, {Name: "artist", Width: 30}, {Name: "duration", Width: 10}},
+			width:   100,
+			headers: []string{"TITLE", "ARTIST", "TIME"},
+			widths:  []int{50, 30, 10},
+		},
+		{
+			name:    "flexible columns share the rest",
+			columns: []api.Column{{Name: "title"}, {Name: "artist"}, {Name: "duration", Width: 10}},
+			width:   100,
+			headers: []string{"TITLE", "ARTIST", "TIME"},
+			widths:  []int{44, 44, 10},
+		},
+		{
+			name:    "unknown columns and case",
+			columns: []api.Column{{Name: "Title", Width: 60}, {Name: "mood", Width: 20}, {Name: "YEAR", Width: 20}},
+			width:   100,
+			headers: []string{"TITLE", "YEAR"},
+			widths:  []int{60, 20},
+		},
+		{
+			name:    "more than 100 percent is scaled down",
+			columns: []api.Column{{Name: "title", Width: 100}, {Name: "artist", Width: 100}},
+			width:   101,
+			headers: []string{"TITLE", "ARTIST"},
+			widths:  []int{50, 50},
+		},
+		{
+			name:    "room is kept for flexible columns",
+			columns: []api.Column{{Name: "title", Width: 100}, {Name: "artist"}},
+			width:   50,
+			headers: []string{"TITLE", "ARTIST"},
+			widths:  []int{48, 1},
+		},
+		{
+			name:    "no width",
+			columns: []api.Column{{Name: "title"}, {Name: "artist"}},
+			width:   0,
+			headers: []string{"TITLE", "ARTIST"},
+			widths:  []int{1, 1},
+		},
+		{
+			name:    "nothing known",
+			columns: []api.Column{{Name: "mood"}},
+			width:   100,
+			headers: []string{},
+			widths:  []int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			columns, widths := layoutColumns(tt.columns, tt.width)
+
+			headers := []string{}
+			for _, c := range columns {
+				headers = append(headers, c.header)
+			}
+			if !slices.Equal(headers, tt.headers) {
+				t.Fatalf("headers %v, want %v", headers, tt.headers)
+			}
+			if !slices.Equal(widths, tt.widths) {
+				t.Fatalf("widths %v, want %v", widths, tt.widths)
+			}
+		})
+	}
+}
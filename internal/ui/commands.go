@@ -1,13 +1,24 @@
 package ui
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/MattiaPun/SubTUI/internal/api"
+	"github.com/MattiaPun/SubTUI/internal/download"
+	"github.com/MattiaPun/SubTUI/internal/library"
 	"github.com/MattiaPun/SubTUI/internal/player"
+	"github.com/MattiaPun/SubTUI/internal/scrobble"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gen2brain/beeep"
 )
 
+// tick is tea.Tick, replaced in tests so timers never fire on their own
+var tick = tea.Tick
+
+// notify is beeep.Notify, replaced in tests so they show no notifications
+var notify = beeep.Notify
+
 func searchCmd(query string, mode int) tea.Cmd {
 	return func() tea.Msg {
 
@@ -17,7 +28,7 @@ func searchCmd(query string, mode int) tea.Cmd {
 			if err != nil {
 				return errMsg{err}
 			}
-			return songsResultMsg{songs}
+			return songsResultMsg{songs, "search", nil}
 
 		case filterAlbums:
 			// Ensure api.SubsonicSearchAlbum exists in your api package!
@@ -25,7 +36,7 @@ func searchCmd(query string, mode int) tea.Cmd {
 			if err != nil {
 				return errMsg{err}
 			}
-			return albumsResultMsg{albums}
+			return albumsResultMsg{albums, nil}
 
 		case filterArtist:
 			// Ensure api.SubsonicSearchArtist exists in your api package!
@@ -46,7 +57,7 @@ func getAlbumSongs(albumID string) tea.Cmd {
 		if err != nil {
 			return errMsg{err}
 		}
-		return songsResultMsg{songs}
+		return songsResultMsg{songs, "album", getAlbumSongs(albumID)}
 	}
 }
 
@@ -56,7 +67,7 @@ func getAlbumList(searchType string) tea.Cmd {
 		if err != nil {
 			return errMsg{err}
 		}
-		return albumsResultMsg{albums}
+		return albumsResultMsg{albums, getAlbumList(searchType)}
 	}
 }
 
@@ -66,7 +77,17 @@ func getArtistAlbums(artistID string) tea.Cmd {
 		if err != nil {
 			return errMsg{err}
 		}
-		return albumsResultMsg{albums}
+		return albumsResultMsg{albums, getArtistAlbums(artistID)}
+	}
+}
+
+func getArtistIndex() tea.Cmd {
+	return func() tea.Msg {
+		index, err := api.SubsonicGetArtists()
+		if err != nil {
+			return errMsg{err}
+		}
+		return artistIndexResultMsg{index, getArtistIndex()}
 	}
 }
 
@@ -86,14 +107,36 @@ func getPlaylistSongs(id string) tea.Cmd {
 		if err != nil {
 			return errMsg{err}
 		}
-		return songsResultMsg{songs}
+		return songsResultMsg{songs, "playlist", getPlaylistSongs(id)}
 	}
 }
 
-func syncPlayerCmd() tea.Cmd {
-	return tea.Tick(time.Millisecond*500, func(t time.Time) tea.Msg {
-		return statusMsg(player.GetPlayerStatus())
-	})
+// waitForCacheUpdate waits for a background refresh to change a cached
+// response.
+func waitForCacheUpdate() tea.Cmd {
+	return func() tea.Msg {
+		<-api.CacheUpdates()
+		return cacheUpdatedMsg{}
+	}
+}
+
+// refreshViewCmd loads the list in view again, for the view it was shown in.
+func refreshViewCmd(reload tea.Cmd, gen int) tea.Cmd {
+	return func() tea.Msg {
+		return viewRefreshedMsg{gen, reload()}
+	}
+}
+
+// waitForPlayerEvent delivers the next player event along with the status
+// after it, until the player closes its events.
+func waitForPlayerEvent(p player.Player) tea.Cmd {
+	return func() tea.Msg {
+		event, ok := <-p.Events()
+		if !ok {
+			return nil
+		}
+		return playerEventMsg{event, p.Status()}
+	}
 }
 
 func getStarredCmd() tea.Cmd {
@@ -138,6 +181,51 @@ func checkLoginCmd() tea.Cmd {
 	}
 }
 
+func notifyPlayingCmd(song api.Song) tea.Cmd {
+	return func() tea.Msg {
+		title := "SubTUI"
+		description := fmt.Sprintf("Playing %s - %s", song.Title, song.Artist)
+
+		artBytes, err := api.SubsonicCoverArt(song.ID)
+		if err != nil {
+			_ = notify(title, description, "")
+		} else {
+			_ = notify(title, description, artBytes)
+		}
+		return nil
+	}
+}
+
+func submitScrobbleCmd(id string, started time.Time) tea.Cmd {
+	return func() tea.Msg {
+		scrobble.Submit(id, started)
+		return nil
+	}
+}
+
+// flushScrobblesCmd sends plays that could not be scrobbled before.
+func flushScrobblesCmd() tea.Cmd {
+	return func() tea.Msg {
+		_ = scrobble.Flush()
+		return nil
+	}
+}
+
+func scrobbleRetryTickCmd() tea.Cmd {
+	return tick(time.Minute, func(time.Time) tea.Msg {
+		return scrobbleRetryTickMsg{}
+	})
+}
+
+func startPlayerCmd(p player.Player) tea.Cmd {
+	return func() tea.Msg {
+		if err := p.Start(); err != nil {
+			return errMsg{err}
+		}
+		return nil
+	}
+}
+
 func getPlayQueue() tea.Cmd {
 	return func() tea.Msg {
 		result, err := api.SubsonicGetQueue()
@@ -160,3 +248,59 @@ func savePlayQueueCmd(ids []string, currentID string) tea.Cmd {
 	}
 
 }
+
+func purgeCacheCmd() tea.Cmd {
+	return func() tea.Msg {
+		if err := api.PurgeCache(); err != nil {
+			return errMsg{err}
+		}
+		return nil
+	}
+}
+
+// Redraws progress of downloads and library syncs, which send no events
+func refreshTickCmd() tea.Cmd {
+	return tick(500*time.Millisecond, func(t time.Time) tea.Msg {
+		return refreshTickMsg{}
+	})
+}
+
+func syncLibraryCmd() tea.Cmd {
+	return func() tea.Msg {
+		if library.SongCount() == 0 {
+			if err := library.Load(); err != nil {
+				return librarySyncedMsg{err}
+			}
+		}
+
+		return librarySyncedMsg{library.Sync()}
+	}
+}
+
+func scheduleLibrarySyncCmd() tea.Cmd {
+	return tick(15*time.Minute, func(t time.Time) tea.Msg {
+		return librarySyncTickMsg{}
+	})
+}
+
+func downloadAlbumCmd(albumID string) tea.Cmd {
+	return func() tea.Msg {
+		songs, err := api.SubsonicGetAlbum(albumID)
+		if err != nil {
+			return errMsg{err}
+		}
+		download.Enqueue(songs)
+		return nil
+	}
+}
+
+func downloadPlaylistCmd(id string) tea.Cmd {
+	return func() tea.Msg {
+		songs, err := api.SubsonicGetPlaylistSongs(id)
+		if err != nil {
+			return errMsg{err}
+		}
+		download.Enqueue(songs)
+		return nil
+	}
+}
@@ -0,0 +1,104 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+	"github.com/MattiaPun/SubTUI/internal/player"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type audioDevicesMsg struct {
+	devices []player.AudioDevice
+}
+
+func getAudioDevicesCmd(p player.Player) tea.Cmd {
+	return func() tea.Msg {
+		devices, err := p.AudioDevices()
+		if err != nil {
+			return errMsg{err}
+		}
+		return audioDevicesMsg{devices}
+	}
+}
+
+func toggleAudioDevices(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	if m.viewMode == viewDevices {
+		m.viewMode = viewList
+		m.displayMode = m.displayModePrev
+	} else {
+		m.viewMode = viewDevices
+		m.displayModePrev = m.displayMode
+	}
+	m.focus = focusMain
+	m.cursorMain = 0
+	m.mainOffset = 0
+
+	if m.viewMode == viewDevices {
+		return m, getAudioDevicesCmd(m.player)
+	}
+	return m, nil
+}
+
+func selectAudioDevice(m model) (model, tea.Cmd) {
+	if m.cursorMain >= len(m.audioDevices) {
+		return m, nil
+	}
+
+	name := m.audioDevices[m.cursorMain].Name
+	if err := m.player.SetAudioDevice(name); err != nil {
+		m.err = err
+		return m, nil
+	}
+
+	// mpv's default needs no option on the next start
+	if name == "auto" {
+		name = ""
+	}
+	api.AppConfig.Player.AudioDevice = name
+	if err := api.SaveConfig(); err != nil {
+		m.err = err
+	}
+
+	return m, nil
+}
+
+func mainDevicesContent(m model, mainWidth int) string {
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(subtle)
+	mainContent := headerStyle.Render("  AUDIO OUTPUT") + "\n"
+	mainContent += lipgloss.NewStyle().Foreground(subtle).Render("  "+strings.Repeat("-", mainWidth-4)) + "\n"
+
+	if len(m.audioDevices) == 0 {
+		return mainContent + "\n  Looking for audio devices..."
+	}
+
+	active := api.AppConfig.Player.AudioDevice
+	if active == "" {
+		active = "auto"
+	}
+
+	for i, device := range m.audioDevices {
+		marker := " "
+		if device.Name == active {
+			marker = "●"
+		}
+
+		cursor := "  "
+		style := lipgloss.NewStyle()
+		if m.cursorMain == i {
+			cursor = "> "
+			style = style.Foreground(highlight).Bold(true)
+		}
+
+		item := fmt.Sprintf("%s %s  %s", marker, device.Description, device.Name)
+		mainContent += cursor + style.Render(LimitString(item, mainWidth-4)) + "\n"
+	}
+
+	return mainContent
+}
@@ -0,0 +1,159 @@
+package ui
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const equalizerMaxGain = 12
+
+func toggleEqualizer(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	if m.viewMode == viewEqualizer {
+		m.viewMode = viewList
+		m.displayMode = m.displayModePrev
+	} else {
+		m.viewMode = viewEqualizer
+		m.displayModePrev = m.displayMode
+	}
+	m.focus = focusMain
+	m.cursorMain = 0
+	m.mainOffset = 0
+
+	return m, nil
+}
+
+// adjustEqualizerBand changes the selected band, which turns the preset
+// into a custom curve.
+func adjustEqualizerBand(m model, delta float64) model {
+	gains := api.EqualizerGains()
+	if m.cursorMain >= len(gains) {
+		return m
+	}
+
+	gains[m.cursorMain] = math.Max(-equalizerMaxGain, math.Min(gains[m.cursorMain]+delta, equalizerMaxGain))
+	api.AppConfig.Equalizer.Gains = gains
+	api.AppConfig.Equalizer.Preset = ""
+
+	return saveEqualizer(m)
+}
+
+func nextEqualizerPreset(m model) model {
+	names := api.EqualizerPresetNames()
+
+	next := names[0]
+	for i, name := range names {
+		if name == api.AppConfig.Equalizer.Preset {
+			next = names[(i+1)%len(names)]
+			break
+		}
+	}
+
+	gains, _ := api.EqualizerPreset(next)
+	api.AppConfig.Equalizer.Gains = gains
+	api.AppConfig.Equalizer.Preset = next
+
+	return saveEqualizer(m)
+}
+
+func submitEqualizerPreset(m model, name string) (model, tea.Cmd) {
+	if name == "" {
+		return m, nil
+	}
+
+	api.SaveEqualizerPreset(name, api.EqualizerGains())
+	return saveEqualizer(m), nil
+}
+
+func saveEqualizer(m model) model {
+	if err := api.SaveConfig(); err != nil {
+		m.err = err
+	}
+
+	return m.applyEqualizer()
+}
+
+// applyEqualizer sends the gains for the current song to the player. With
+// auto_genre, the preset of the song's genre wins over the user's choice.
+func (m model) applyEqualizer() model {
+	gains := api.EqualizerGains()
+
+	if api.AppConfig.Equalizer.AutoGenre && len(m.queue) > 0 && m.queueIndex < len(m.queue) {
+		if preset, ok := api.GenreEqualizerPreset(m.queue[m.queueIndex].Genre); ok {
+			if presetGains, ok := api.EqualizerPreset(preset); ok {
+				gains = presetGains
+			}
+		}
+	}
+
+	key := fmt.Sprint(gains)
+	if key != m.equalizerApplied {
+		m.player.SetEqualizer(gains)
+		m.equalizerApplied = key
+	}
+
+	return m
+}
+
+func mainEqualizerContent(m model, mainWidth int) string {
+	preset := api.AppConfig.Equalizer.Preset
+	if preset == "" {
+		preset = "custom"
+	}
+
+	auto := ""
+	if api.AppConfig.Equalizer.AutoGenre {
+		auto = ", per genre"
+	}
+
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(subtle)
+	mainContent := headerStyle.Render(fmt.Sprintf("  EQUALIZER (%s%s)", preset, auto)) + "\n"
+	mainContent += lipgloss.NewStyle().Foreground(subtle).Render("  "+strings.Repeat("-", mainWidth-4)) + "\n"
+
+	// Each side of the slider covers equalizerMaxGain dB
+	half := (mainWidth - 4 - 10 - 10) / 2
+	if half < 4 {
+		half = 4
+	}
+
+	for i, gain := range api.EqualizerGains() {
+		label := fmt.Sprintf("%5s Hz", formatFrequency(api.EqualizerBands[i]))
+		filled := int(math.Round(math.Abs(gain) / equalizerMaxGain * float64(half)))
+
+		left := strings.Repeat("─", half)
+		right := strings.Repeat("─", half)
+		if gain < 0 {
+			left = strings.Repeat("─", half-filled) + strings.Repeat("█", filled)
+		} else {
+			right = strings.Repeat("█", filled) + strings.Repeat("─", half-filled)
+		}
+
+		cursor := "  "
+		style := lipgloss.NewStyle()
+		if i == m.cursorMain && m.focus == focusMain {
+			cursor = "> "
+			style = style.Foreground(highlight).Bold(true)
+		}
+
+		mainContent += style.Render(fmt.Sprintf("%s%s %s│%s %+5.1f dB", cursor, label, left, right, gain)) + "\n"
+	}
+
+	mainContent += "\n" + lipgloss.NewStyle().Foreground(subtle).Render("  ←/→ adjust band • enter next preset • s save as preset")
+
+	return mainContent
+}
+
+func formatFrequency(hz int) string {
+	if hz >= 1000 {
+		return fmt.Sprintf("%dk", hz/1000)
+	}
+	return fmt.Sprint(hz)
+}
@@ -1,7 +1,11 @@
 package ui
 
 import (
+	"strings"
+	"time"
+
 	"github.com/MattiaPun/SubTUI/internal/api"
+	"github.com/MattiaPun/SubTUI/internal/history"
 	"github.com/MattiaPun/SubTUI/internal/player"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
@@ -18,6 +22,11 @@ const (
 const (
 	viewList = iota
 	viewQueue
+	viewProfiles
+	viewDownloads
+	viewEqualizer
+	viewDevices
+	viewStats
 	viewLogin = 99
 )
 
@@ -25,6 +34,7 @@ const (
 	filterSongs = iota
 	filterAlbums
 	filterArtist
+	filterLocal
 )
 
 const (
@@ -33,6 +43,14 @@ const (
 	displayArtist
 )
 
+const (
+	promptNone = iota
+	promptSeek
+	promptEqualizerPreset
+	promptFade
+	promptSleep
+)
+
 const (
 	LoopNone = 0
 	LoopAll  = 1
@@ -43,9 +61,10 @@ var albumTypes = []string{"Random", "Favorites", "Recently Added", "Recently Pla
 
 var (
 	// Colors
-	subtle    = lipgloss.AdaptiveColor{Light: "#D9DCCF", Dark: "#6b6b6bff"}
-	highlight = lipgloss.AdaptiveColor{Light: "#874BFD", Dark: "#7D56F4"}
-	special   = lipgloss.AdaptiveColor{Light: "#43BF6D", Dark: "#73F59F"}
+	subtle     = lipgloss.AdaptiveColor{Light: "#D9DCCF", Dark: "#6b6b6bff"}
+	highlight  = lipgloss.AdaptiveColor{Light: "#874BFD", Dark: "#7D56F4"}
+	special    = lipgloss.AdaptiveColor{Light: "#43BF6D", Dark: "#73F59F"}
+	errorColor = lipgloss.Color("#FF5F5F")
 
 	// Global Borders
 	borderStyle = lipgloss.NewStyle().
@@ -82,6 +101,8 @@ type model struct {
 	albums       []api.Album
 	artists      []api.Artist
 	playlists    []api.Playlist
+	player       player.Player
+	refreshing   bool
 	playerStatus player.PlayerStatus
 
 	// Navigation State
@@ -104,6 +125,7 @@ type model struct {
 	err              error
 	loading          bool
 	lastPlayedSongID string
+	playStarted      time.Time
 	scrobbled        bool
 
 	// Queue System
@@ -111,29 +133,92 @@ type model struct {
 	queueIndex int
 	loopMode   int
 
+	// Artist Index
+	artistGroups []string
+
+	// Sleep timer, see sleep.go
+	sleepMode        int
+	sleepAt          time.Time
+	sleepFade        bool
+	sleepFading      bool
+	sleepVolume      float64 // Volume before the fade started
+	sleepTracks      int     // Songs left, counting the current one
+	sleepAlbumID     string
+	sleepTicking     bool
+	stopAfterCurrent bool
+
+	// Listening history, see stats.go
+	play         history.Play
+	playTrack    int
+	playPosition float64
+	stats        history.Stats
+	statsPeriod  int
+
+	// Outputs offered by the player
+	audioDevices []player.AudioDevice
+
+	// Gains last sent to the player
+	equalizerApplied string
+
+	// Volume keys pressed, and whether the last volume is not saved yet
+	volumeChanges int
+	volumeUnsaved bool
+
 	// Stars
 	starredMap map[string]bool
 
+	// Column layout of m.songs: search, album or playlist
+	songsView string
+
+	// Loads the list in view again, and counts the lists shown so a reload
+	// that comes back late is dropped
+	reload  tea.Cmd
+	viewGen int
+
+	// Local Library Search
+	localMatches map[string]map[int][]int
+
 	// Login State
 	loginInputs []textinput.Model
 	loginFocus  int
 
 	// Input State
 	lastKey string
+
+	// One line prompt shown in place of the search bar
+	prompt     textinput.Model
+	promptMode int
 }
 
+// Lists loaded from cacheable responses carry the command that loads them
+// again, see cacheUpdatedMsg
 type songsResultMsg struct {
-	songs []api.Song
+	songs  []api.Song
+	view   string
+	reload tea.Cmd
 }
 
 type albumsResultMsg struct {
 	albums []api.Album
+	reload tea.Cmd
 }
 
 type artistsResultMsg struct {
 	artists []api.Artist
 }
 
+type artistIndexResultMsg struct {
+	index  []api.ArtistIndex
+	reload tea.Cmd
+}
+
+type cacheUpdatedMsg struct{}
+
+type viewRefreshedMsg struct {
+	gen int
+	msg tea.Msg
+}
+
 type playlistResultMsg struct {
 	playlists []api.Playlist
 }
@@ -148,13 +233,36 @@ type playQueueResultMsg struct {
 
 type viewLikedSongsMsg *api.SearchResult3
 
+type librarySyncedMsg struct {
+	err error
+}
+
+type librarySyncTickMsg struct{}
+
+type refreshTickMsg struct{}
+
+type scrobbleRetryTickMsg struct{}
+
+type passwordCommandMsg struct {
+	name string
+	out  *strings.Builder
+	err  error
+}
+
+type saveVolumeMsg struct {
+	changes int
+}
+
 type errMsg struct {
 	err error
 }
 
-type statusMsg player.PlayerStatus
+type playerEventMsg struct {
+	event  player.Event
+	status player.PlayerStatus
+}
 
-func InitialModel() model {
+func InitialModel(p player.Player) model {
 	ti := textinput.New()
 	ti.Placeholder = "Search songs..."
 	ti.Focus()
@@ -162,12 +270,15 @@ func InitialModel() model {
 	ti.Width = 50
 
 	startMode := viewList
-	if api.AppConfig.Username == "" || api.AppConfig.Password == "" || api.AppConfig.URL == "" {
+	profile := api.CurrentProfile()
+	if profile.Username == "" || profile.Password == "" || profile.URL == "" {
 		startMode = viewLogin
 	}
 
 	return model{
 		textInput:        ti,
+		player:           p,
+		refreshing:       api.AppConfig.LibraryIndex, // Init starts the refresh
 		songs:            []api.Song{},
 		focus:            focusSearch,
 		cursorMain:       0,
@@ -175,6 +286,7 @@ func InitialModel() model {
 		viewMode:         startMode,
 		filterMode:       filterSongs,
 		displayMode:      displaySongs,
+		songsView:        "search",
 		starredMap:       make(map[string]bool),
 		lastPlayedSongID: "",
 		loginInputs:      initialLoginInputs(),
@@ -183,17 +295,32 @@ func InitialModel() model {
 }
 
 func (m model) Init() tea.Cmd {
-	return tea.Batch(
+	cmds := []tea.Cmd{
 		textinput.Blink,
 		getPlaylists(),
-		getPlayQueue(),
-		syncPlayerCmd(),
+		waitForPlayerEvent(m.player),
+		waitForCacheUpdate(),
 		getStarredCmd(),
-	)
+		flushScrobblesCmd(),
+		scrobbleRetryTickCmd(),
+	}
+
+	// The saved queue is loaded into the player, so it has to be running
+	if m.viewMode == viewLogin {
+		cmds = append(cmds, getPlayQueue())
+	} else {
+		cmds = append(cmds, tea.Sequence(startPlayerCmd(m.player), getPlayQueue()))
+	}
+
+	if api.AppConfig.LibraryIndex {
+		cmds = append(cmds, syncLibraryCmd(), refreshTickCmd())
+	}
+
+	return tea.Batch(cmds...)
 }
 
 func initialLoginInputs() []textinput.Model {
-	inputs := make([]textinput.Model, 3)
+	inputs := make([]textinput.Model, 4)
 
 	inputs[0] = textinput.New()
 	inputs[0].Placeholder = "http(s)://music.example.com"
@@ -212,5 +339,20 @@ func initialLoginInputs() []textinput.Model {
 	inputs[2].Width = 30
 	inputs[2].Prompt = "Password: "
 
+	inputs[3] = textinput.New()
+	inputs[3].Placeholder = "home"
+	inputs[3].Width = 30
+	inputs[3].Prompt = "Profile:  "
+
+	// The encrypted password store needs a passphrase to save the password
+	if api.NeedsPassphrase() {
+		passphrase := textinput.New()
+		passphrase.Placeholder = "protects saved passwords"
+		passphrase.EchoMode = textinput.EchoPassword
+		passphrase.Width = 30
+		passphrase.Prompt = "Passphrase:"
+		inputs = append(inputs, passphrase)
+	}
+
 	return inputs
 }
@@ -0,0 +1,104 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func openPrompt(m model, mode int, label string, placeholder string) model {
+	m.prompt = textinput.New()
+	m.prompt.Prompt = label
+	m.prompt.Placeholder = placeholder
+	m.prompt.CharLimit = 32
+	m.prompt.Width = 30
+	m.prompt.Focus()
+	m.promptMode = mode
+
+	return m
+}
+
+func updatePrompt(m model, msg tea.KeyMsg) (model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.promptMode = promptNone
+		return m, nil
+
+	case "enter":
+		mode := m.promptMode
+		value := strings.TrimSpace(m.prompt.Value())
+		m.promptMode = promptNone
+
+		switch mode {
+		case promptSeek:
+			return submitSeek(m, value)
+		case promptEqualizerPreset:
+			return submitEqualizerPreset(m, value)
+		case promptFade:
+			return submitFade(m, value)
+		case promptSleep:
+			return submitSleep(m, value)
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.prompt, cmd = m.prompt.Update(msg)
+	return m, cmd
+}
+
+func submitSeek(m model, value string) (model, tea.Cmd) {
+	seconds, err := parseTimestamp(value, m.playerStatus.Duration)
+	if err != nil {
+		m.err = err
+		return m, nil
+	}
+
+	m.player.SeekTo(seconds)
+	return m, nil
+}
+
+func submitFade(m model, value string) (model, tea.Cmd) {
+	if value == "" {
+		return m, nil
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 || seconds > 30 {
+		m.err = fmt.Errorf("invalid fade %q, expected 0 to 30 seconds", value)
+		return m, nil
+	}
+
+	api.AppConfig.Player.Fade = seconds
+	if err := api.SaveConfig(); err != nil {
+		m.err = err
+	}
+
+	return m, m.refreshPlaylist()
+}
+
+// parseTimestamp accepts seconds, m:ss, h:mm:ss or a percentage of duration.
+func parseTimestamp(value string, duration float64) (float64, error) {
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p < 0 || p > 100 {
+			return 0, fmt.Errorf("invalid percentage %q", value)
+		}
+		return duration * p / 100, nil
+	}
+
+	seconds := 0.0
+	for _, part := range strings.Split(value, ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		seconds = seconds*60 + n
+	}
+
+	return seconds, nil
+}
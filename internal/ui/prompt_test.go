@@ -0,0 +1,36 @@
+package ui
+
+import "testing"
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{"90", 90, false},
+		{"1:30", 90, false},
+		{"1:02:03", 3723, false},
+		{"2.5", 2.5, false},
+		{"50%", 100, false},
+		{"0%", 0, false},
+		{"100%", 200, false},
+		{"101%", 0, true},
+		{"-5", 0, true},
+		{"1:-5", 0, true},
+		{"abc", 0, true},
+		{"", 0, true},
+		{"%", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTimestamp(tt.value, 200)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimestamp(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseTimestamp(%q) = %g, want %g", tt.value, got, tt.want)
+		}
+	}
+}
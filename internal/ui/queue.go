@@ -2,6 +2,7 @@ package ui
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/MattiaPun/SubTUI/internal/api"
 	"github.com/MattiaPun/SubTUI/internal/player"
@@ -21,9 +22,11 @@ func (m *model) playQueueIndex(index int, startPaused bool) tea.Cmd {
 
 	m.queueIndex = index
 	song := m.queue[m.queueIndex]
+	gain := m.replayGain(m.queueIndex)
 
+	p := m.player
 	playCmd := func() tea.Msg {
-		err := player.PlaySong(song.ID, startPaused)
+		err := p.Load(song.ID, gain, startPaused)
 		if err != nil {
 			return errMsg{err}
 		}
@@ -63,6 +66,179 @@ func (m *model) playPrev() tea.Cmd {
 	return m.playQueueIndex(newIndex, false)
 }
 
+// nextIndex returns the queue index that plays after the current song, or -1
+func (m *model) nextIndex() int {
+	if len(m.queue) == 0 {
+		return -1
+	}
+
+	switch m.loopMode {
+	case LoopOne:
+		return m.queueIndex
+	case LoopAll:
+		return (m.queueIndex + 1) % len(m.queue)
+	}
+
+	if m.queueIndex+1 < len(m.queue) {
+		return m.queueIndex + 1
+	}
+
+	return -1
+}
+
+// advancedIndex finds the queue index of the song mpv advanced to on its own
+func (m *model) advancedIndex(songID string) int {
+	if next := m.nextIndex(); next >= 0 && m.queue[next].ID == songID {
+		return next
+	}
+
+	for i := range m.queue {
+		index := (m.queueIndex + 1 + i) % len(m.queue)
+		if m.queue[index].ID == songID {
+			return index
+		}
+	}
+
+	return m.queueIndex
+}
+
+// prefetchNext keeps mpv's playlist in line with the queue, so the next song
+// starts without a gap. It only acts once mpv is playing the current song.
+func (m *model) prefetchNext() tea.Cmd {
+	nextID := ""
+	gain := 0.0
+	fade := 0.0
+	if len(m.queue) > 0 {
+		if m.queueIndex >= len(m.queue) || m.playerStatus.SongID != m.queue[m.queueIndex].ID {
+			return nil
+		}
+
+		if next := m.nextIndex(); next >= 0 && !m.stopsBefore(next) {
+			nextID = m.queue[next].ID
+			gain = m.replayGain(next)
+			fade = m.transitionFade(next)
+		}
+	}
+
+	if nextID == m.playerStatus.NextID {
+		return nil
+	}
+
+	p := m.player
+	return func() tea.Msg {
+		if err := p.Prefetch(nextID, gain, fade); err != nil {
+			return errMsg{err}
+		}
+		return nil
+	}
+}
+
+// transitionFade returns the seconds to fade out of the current song and
+// into queue entry index, none when it continues the current song's album in
+// track order.
+func (m *model) transitionFade(index int) float64 {
+	seconds := api.AppConfig.Player.Fade
+	if seconds <= 0 || m.queueIndex >= len(m.queue) {
+		return 0
+	}
+
+	current, next := m.queue[m.queueIndex], m.queue[index]
+	if current.AlbumID != "" && current.AlbumID == next.AlbumID && albumPosition(next) > albumPosition(current) {
+		return 0
+	}
+
+	return seconds
+}
+
+// contentKind tells music, podcasts and audiobooks apart, which each keep
+// their own playback speed.
+func contentKind(song api.Song) string {
+	switch kind := strings.ToLower(song.Type); kind {
+	case "podcast", "audiobook":
+		return kind
+	}
+
+	genre := strings.ToLower(song.Genre)
+	switch {
+	case strings.Contains(genre, "podcast"):
+		return "podcast"
+	case strings.Contains(genre, "audiobook"), strings.Contains(genre, "audio book"):
+		return "audiobook"
+	}
+
+	return "music"
+}
+
+// replayGain picks the track or album gain for a queue entry. In auto mode
+// the album gain is used while the queue plays an album in order.
+func (m *model) replayGain(index int) float64 {
+	album := false
+	switch api.AppConfig.ReplayGain.Mode {
+	case "album":
+		album = true
+	case "auto":
+		album = m.playingAlbum(index)
+	}
+
+	return player.ReplayGain(m.queue[index], album)
+}
+
+// playingAlbum reports whether a neighbouring queue entry is from the same
+// album and in track order
+func (m *model) playingAlbum(index int) bool {
+	song := m.queue[index]
+	if song.AlbumID == "" {
+		return false
+	}
+
+	if index > 0 {
+		prev := m.queue[index-1]
+		if prev.AlbumID == song.AlbumID && albumPosition(prev) < albumPosition(song) {
+			return true
+		}
+	}
+
+	if index+1 < len(m.queue) {
+		next := m.queue[index+1]
+		if next.AlbumID == song.AlbumID && albumPosition(next) > albumPosition(song) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func albumPosition(s api.Song) int {
+	return s.DiscNumber*1000 + s.Track
+}
+
+// refreshPlaylist re-applies the gain of the current song and prefetches the
+// next one again, after the gain mode or fade changed.
+func (m *model) refreshPlaylist() tea.Cmd {
+	if len(m.queue) == 0 || m.queueIndex >= len(m.queue) {
+		return nil
+	}
+
+	gain := m.replayGain(m.queueIndex)
+	nextID := ""
+	nextGain := 0.0
+	nextFade := 0.0
+	if next := m.nextIndex(); next >= 0 && !m.stopsBefore(next) {
+		nextID = m.queue[next].ID
+		nextGain = m.replayGain(next)
+		nextFade = m.transitionFade(next)
+	}
+
+	p := m.player
+	return func() tea.Msg {
+		p.SetReplayGain(gain)
+		if err := p.Prefetch(nextID, nextGain, nextFade); err != nil {
+			return errMsg{err}
+		}
+		return nil
+	}
+}
+
 func (m *model) setQueue(startIndex int) tea.Cmd {
 	newQueue := make([]api.Song, len(m.songs))
 	copy(newQueue, m.songs)
@@ -0,0 +1,60 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type remoteCommandMsg struct {
+	action string
+	query  string
+}
+
+type remoteSongsMsg struct {
+	songs []api.Song
+	play  bool
+}
+
+// RemoteCommand returns the message to send to the program for a command
+// forwarded by another subtui invocation. Searches run here, so the sender is
+// told when nothing was found.
+func RemoteCommand(action string, query string) (tea.Msg, error) {
+	if action == "toggle" {
+		return remoteCommandMsg{action, query}, nil
+	}
+
+	songs, err := api.SubsonicSearchSong(query, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(songs) == 0 {
+		return nil, fmt.Errorf("no songs found for %q", query)
+	}
+
+	return remoteSongsMsg{songs, action == "play"}, nil
+}
+
+func runRemoteCommand(m model, msg remoteCommandMsg) (model, tea.Cmd) {
+	switch msg.action {
+	case "toggle":
+		if m.playerStatus.SongID == "" && len(m.queue) > 0 {
+			return m, m.playQueueIndex(m.queueIndex, false)
+		}
+		m.player.TogglePause()
+	}
+
+	return m, nil
+}
+
+// Play replaces the queue with everything found, queue appends the best match.
+func addRemoteSongs(m model, msg remoteSongsMsg) (model, tea.Cmd) {
+	if !msg.play {
+		m.queue = append(m.queue, msg.songs[0])
+		return m, tea.Batch(m.savePlayQueue(), m.prefetchNext())
+	}
+
+	m.queue = msg.songs
+	return m, m.playQueueIndex(0, false)
+}
@@ -0,0 +1,197 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	sleepNone = iota
+	sleepTime
+	sleepTracks
+	sleepAlbum
+)
+
+// How long the volume takes to fade out before the timer pauses playback
+const sleepFadeDuration = time.Minute
+
+type sleepTickMsg struct{}
+
+func sleepTickCmd() tea.Cmd {
+	return tick(time.Second, func(time.Time) tea.Msg {
+		return sleepTickMsg{}
+	})
+}
+
+func mediaSleepPrompt(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	return openPrompt(m, promptSleep, "Sleep: ", "30, 30f to fade, 3t songs, album, off"), textinput.Blink
+}
+
+func mediaToggleStopAfterCurrent(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	m.stopAfterCurrent = !m.stopAfterCurrent
+	return m, m.prefetchNext()
+}
+
+// submitSleep accepts minutes, minutes followed by f to fade out, a number of
+// songs followed by t, "album" for the end of the current album, or "off".
+func submitSleep(m model, value string) (model, tea.Cmd) {
+	value = strings.ToLower(value)
+	m = m.cancelSleep()
+
+	switch {
+	case value == "" || value == "off" || value == "0":
+		return m, m.prefetchNext()
+
+	case value == "album":
+		if len(m.queue) == 0 || m.queueIndex >= len(m.queue) {
+			return m, nil
+		}
+		m.sleepMode = sleepAlbum
+		m.sleepAlbumID = m.queue[m.queueIndex].AlbumID
+		return m, m.prefetchNext()
+
+	case strings.HasSuffix(value, "t"):
+		count, err := strconv.Atoi(strings.TrimSuffix(value, "t"))
+		if err != nil || count < 1 {
+			m.err = fmt.Errorf("invalid number of songs %q", value)
+			return m, nil
+		}
+		m.sleepMode = sleepTracks
+		m.sleepTracks = count
+		return m, m.prefetchNext()
+	}
+
+	fade := strings.HasSuffix(value, "f")
+	minutes, err := strconv.ParseFloat(strings.TrimSuffix(value, "f"), 64)
+	if err != nil || minutes <= 0 {
+		m.err = fmt.Errorf("invalid sleep timer %q", value)
+		return m, nil
+	}
+
+	m.sleepMode = sleepTime
+	m.sleepAt = time.Now().Add(time.Duration(minutes * float64(time.Minute)))
+	m.sleepFade = fade
+
+	if m.sleepTicking {
+		return m, nil
+	}
+	m.sleepTicking = true
+	return m, sleepTickCmd()
+}
+
+// cancelSleep clears the timer and undoes a fade that is in progress.
+func (m model) cancelSleep() model {
+	if m.sleepFading {
+		m.player.SetVolume(m.sleepVolume)
+	}
+
+	m.sleepMode = sleepNone
+	m.sleepFade = false
+	m.sleepFading = false
+	m.sleepTracks = 0
+	m.sleepAlbumID = ""
+
+	return m
+}
+
+// updateSleep runs every second while a timed sleep is set.
+func updateSleep(m model) (model, tea.Cmd) {
+	if m.sleepMode != sleepTime {
+		m.sleepTicking = false
+		return m, nil
+	}
+
+	remaining := time.Until(m.sleepAt)
+
+	if remaining <= 0 {
+		if !m.playerStatus.Paused && m.playerStatus.SongID != "" {
+			m.player.TogglePause()
+		}
+		m.sleepTicking = false
+		return m.cancelSleep(), nil
+	}
+
+	if m.sleepFade && remaining < sleepFadeDuration {
+		if !m.sleepFading {
+			m.sleepFading = true
+			m.sleepVolume = m.playerStatus.Volume
+		}
+		m.player.SetVolume(m.sleepVolume * remaining.Seconds() / sleepFadeDuration.Seconds())
+	}
+
+	return m, sleepTickCmd()
+}
+
+// trackStarted counts down the songs of a sleep timer.
+func (m model) trackStarted() model {
+	if m.sleepMode == sleepTracks && m.sleepTracks > 1 {
+		m.sleepTracks--
+	}
+
+	return m
+}
+
+// stopsBefore reports whether playback ends before queue entry next, so it
+// must not be prefetched.
+func (m *model) stopsBefore(next int) bool {
+	if m.stopAfterCurrent {
+		return true
+	}
+
+	switch m.sleepMode {
+	case sleepTracks:
+		return m.sleepTracks <= 1
+	case sleepAlbum:
+		current := m.queue[m.queueIndex]
+		return current.AlbumID != m.sleepAlbumID || m.queue[next].AlbumID != m.sleepAlbumID ||
+			albumPosition(m.queue[next]) <= albumPosition(current)
+	}
+
+	return false
+}
+
+// stopped clears the one-shot stops once the player ran out of songs.
+func (m model) stopped() model {
+	m.stopAfterCurrent = false
+	if m.sleepMode == sleepTracks || m.sleepMode == sleepAlbum {
+		m = m.cancelSleep()
+	}
+
+	return m
+}
+
+func sleepText(m model) string {
+	text := ""
+
+	switch m.sleepMode {
+	case sleepTime:
+		remaining := time.Until(m.sleepAt).Round(time.Second)
+		text = fmt.Sprintf("[Sleep %s]", formatDuration(int(remaining.Seconds())))
+	case sleepTracks:
+		text = "[Stop after song]"
+		if m.sleepTracks > 1 {
+			text = fmt.Sprintf("[Stop after %d songs]", m.sleepTracks)
+		}
+	case sleepAlbum:
+		text = "[Sleep after album]"
+	}
+
+	if m.stopAfterCurrent {
+		text = "[Stop after song]"
+	}
+
+	return text
+}
@@ -0,0 +1,71 @@
+package ui
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+)
+
+func TestSubmitSleep(t *testing.T) {
+	tests := []struct {
+		value   string
+		mode    int
+		minutes float64
+		fade    bool
+		tracks  int
+		album   string
+		wantErr bool
+	}{
+		{value: "30", mode: sleepTime, minutes: 30},
+		{value: "1.5", mode: sleepTime, minutes: 1.5},
+		{value: "30f", mode: sleepTime, minutes: 30, fade: true},
+		{value: "30F", mode: sleepTime, minutes: 30, fade: true},
+		{value: "3t", mode: sleepTracks, tracks: 3},
+		{value: "album", mode: sleepAlbum, album: "album-1"},
+		{value: "off", mode: sleepNone},
+		{value: "0", mode: sleepNone},
+		{value: "", mode: sleepNone},
+		{value: "0t", mode: sleepNone, wantErr: true},
+		{value: "xt", mode: sleepNone, wantErr: true},
+		{value: "-5", mode: sleepNone, wantErr: true},
+		{value: "soon", mode: sleepNone, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			h := newHarness(t)
+			h.m.queue = []api.Song{{ID: "a", AlbumID: "album-1"}}
+
+			// A timer set before is replaced either way
+			h.m.sleepMode = sleepTracks
+			h.m.sleepTracks = 7
+
+			before := time.Now()
+			m, _ := submitSleep(h.m, tt.value)
+
+			if (m.err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", m.err, tt.wantErr)
+			}
+			if m.sleepMode != tt.mode {
+				t.Fatalf("sleep mode is %d, want %d", m.sleepMode, tt.mode)
+			}
+			if m.sleepFade != tt.fade {
+				t.Fatalf("fade is %v, want %v", m.sleepFade, tt.fade)
+			}
+			if m.sleepTracks != tt.tracks {
+				t.Fatalf("sleeping after %d songs, want %d", m.sleepTracks, tt.tracks)
+			}
+			if m.sleepAlbumID != tt.album {
+				t.Fatalf("sleeping after album %q, want %q", m.sleepAlbumID, tt.album)
+			}
+
+			if tt.mode == sleepTime {
+				want := before.Add(time.Duration(tt.minutes * float64(time.Minute)))
+				if d := m.sleepAt.Sub(want); d < 0 || d > time.Second {
+					t.Fatalf("sleeping at %v, want %v", m.sleepAt, want)
+				}
+			}
+		})
+	}
+}
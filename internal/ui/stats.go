@@ -0,0 +1,234 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MattiaPun/SubTUI/internal/history"
+	"github.com/MattiaPun/SubTUI/internal/player"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var statsPeriods = []struct {
+	name string
+	days int // 0 for all time
+}{
+	{"Last 7 days", 7},
+	{"Last 30 days", 30},
+	{"Last year", 365},
+	{"All time", 0},
+}
+
+type statsResultMsg struct {
+	stats history.Stats
+}
+
+func getStatsCmd(period int) tea.Cmd {
+	return func() tea.Msg {
+		since := time.Time{}
+		if days := statsPeriods[period].days; days > 0 {
+			since = time.Now().AddDate(0, 0, -days)
+		}
+
+		stats, err := history.Compute(since)
+		if err != nil {
+			return errMsg{err}
+		}
+		return statsResultMsg{stats}
+	}
+}
+
+func toggleStats(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	if m.viewMode == viewStats {
+		m.viewMode = viewList
+		m.displayMode = m.displayModePrev
+	} else {
+		m.viewMode = viewStats
+		m.displayModePrev = m.displayMode
+	}
+	m.focus = focusMain
+	m.cursorMain = 0
+	m.mainOffset = 0
+
+	if m.viewMode == viewStats {
+		return m, getStatsCmd(m.statsPeriod)
+	}
+	return m, nil
+}
+
+func changeStatsPeriod(m model, delta int) (model, tea.Cmd) {
+	m.statsPeriod = (m.statsPeriod + delta + len(statsPeriods)) % len(statsPeriods)
+	return m, getStatsCmd(m.statsPeriod)
+}
+
+// trackPlay follows the song the player is on, counting the seconds played
+// without seeks, and records it in the history once playback moved on.
+func (m model) trackPlay(event player.Event, status player.PlayerStatus) model {
+	if m.play.SongID != "" {
+		if event.Type == player.EventTrackEnded && event.Reason == player.EndEOF && event.SongID == m.play.SongID {
+			m.play.Completed = true
+		}
+
+		if status.SongID == m.play.SongID && status.Track == m.playTrack {
+			if delta := status.Current - m.playPosition; delta > 0 && delta <= 2*max(status.Speed, 1) {
+				m.play.Listened += delta
+			}
+			m.playPosition = status.Current
+			if status.Duration > 0 {
+				m.play.Duration = status.Duration
+			}
+			return m
+		}
+
+		m = m.finishPlay()
+	}
+
+	if status.SongID == "" {
+		return m
+	}
+
+	// A song missing from the queue is left over from before the session was
+	// reset, e.g. for another profile
+	for i := range m.queue {
+		song := m.queue[(m.queueIndex+i)%len(m.queue)]
+		if song.ID != status.SongID {
+			continue
+		}
+
+		m.play = history.Play{
+			SongID:   status.SongID,
+			Title:    song.Title,
+			Artist:   song.Artist,
+			Album:    song.Album,
+			Genre:    song.Genre,
+			Started:  time.Now(),
+			Duration: status.Duration,
+		}
+		if m.play.Duration == 0 {
+			m.play.Duration = float64(song.Duration)
+		}
+		m.playTrack = status.Track
+		m.playPosition = status.Current
+		break
+	}
+
+	return m
+}
+
+// finishPlay records the current play, unless nothing of it was heard, like
+// a queue restored paused on startup.
+func (m model) finishPlay() model {
+	play := m.play
+	m.play = history.Play{}
+
+	if play.SongID == "" || play.Listened <= 0 {
+		return m
+	}
+
+	// The end of the song may come after the next one started
+	if play.Duration > 0 && m.playPosition >= play.Duration-5 {
+		play.Completed = true
+	}
+	play.Ended = time.Now()
+
+	if err := history.Record(play); err != nil {
+		m.err = err
+	}
+
+	return m
+}
+
+func mainStatsContent(m model, mainWidth int) string {
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(subtle)
+	subtleStyle := lipgloss.NewStyle().Foreground(subtle)
+
+	mainContent := headerStyle.Render(fmt.Sprintf("  STATS < %s >", statsPeriods[m.statsPeriod].name)) + "\n"
+	mainContent += subtleStyle.Render("  "+strings.Repeat("-", mainWidth-4)) + "\n"
+
+	stats := m.stats
+	if stats.Total.Plays == 0 {
+		return mainContent + "\n  Nothing played in this period yet."
+	}
+
+	mainContent += fmt.Sprintf("  %s listened • %d plays • %.0f%% of each song on average • %.0f%% skipped\n\n",
+		formatListeningTime(stats.Total.Seconds), stats.Total.Plays, stats.Total.Percent(), stats.Total.SkipRate())
+
+	half := (mainWidth - 6) / 2
+	mainContent += joinColumns(statsList("TOP SONGS", stats.Songs, half), statsList("TOP ARTISTS", stats.Artists, half), half) + "\n"
+	mainContent += joinColumns(statsList("TOP ALBUMS", stats.Albums, half), statsList("TOP GENRES", stats.Genres, half), half) + "\n"
+	mainContent += statsHeatmap(stats)
+
+	mainContent += "\n" + subtleStyle.Render("  ←/→ change period")
+
+	return mainContent
+}
+
+const statsListLength = 5
+
+func statsList(title string, counts []history.Count, width int) []string {
+	lines := []string{lipgloss.NewStyle().Bold(true).Render(title)}
+
+	for i, c := range counts {
+		if i == statsListLength {
+			break
+		}
+		detail := fmt.Sprintf(" %5d× %3.0f%% skipped", c.Plays, c.SkipRate())
+		lines = append(lines, fmt.Sprintf("%d. %s%s", i+1, LimitString(c.Name, width-4-lipgloss.Width(detail)), detail))
+	}
+
+	for len(lines) <= statsListLength {
+		lines = append(lines, "")
+	}
+
+	return lines
+}
+
+func joinColumns(left []string, right []string, width int) string {
+	result := ""
+	for i := range left {
+		result += "  " + lipgloss.NewStyle().Width(width+2).Render(left[i]) + right[i] + "\n"
+	}
+	return result
+}
+
+// statsHeatmap shades the hours of each weekday by time listened.
+func statsHeatmap(stats history.Stats) string {
+	shades := []string{"·", "░", "▒", "▓", "█"}
+
+	most := 0.0
+	for _, day := range stats.Hours {
+		for _, seconds := range day {
+			most = max(most, seconds)
+		}
+	}
+
+	heatmap := lipgloss.NewStyle().Bold(true).Render("  BY HOUR") + "\n"
+	heatmap += "      0     6     12    18\n"
+	for day, name := range []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"} {
+		row := "  " + name + " "
+		for _, seconds := range stats.Hours[day] {
+			shade := 0
+			if seconds > 0 {
+				shade = 1 + int(seconds/most*float64(len(shades)-2)+0.5)
+			}
+			row += lipgloss.NewStyle().Foreground(highlight).Render(shades[shade])
+		}
+		heatmap += row + "\n"
+	}
+
+	return heatmap
+}
+
+func formatListeningTime(seconds float64) string {
+	minutes := int(seconds / 60)
+	if minutes < 60 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %02dm", minutes/60, minutes%60)
+}
@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/MattiaPun/SubTUI/internal/api"
+	"github.com/MattiaPun/SubTUI/internal/download"
+	"github.com/MattiaPun/SubTUI/internal/library"
 	"github.com/MattiaPun/SubTUI/internal/player"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/gen2brain/beeep"
 )
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -30,13 +33,26 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 
 		if msg.String() == "ctrl+c" {
+			m = m.finishPlay()
 			return m, tea.Quit
 		}
 
+		// Errors stay on screen until the next key
+		m.err = nil
+
 		if m.viewMode == viewLogin {
 			return login(m, msg)
 		}
 
+		if m.promptMode != promptNone {
+			return updatePrompt(m, msg)
+		}
+
+		if m.lastKey == "'" && m.focus == focusMain {
+			m.lastKey = ""
+			return jumpToLetter(m, msg.String()), nil
+		}
+
 		if (msg.String() == "g" || m.lastKey == "g") && (m.focus == focusMain || m.focus == focusSidebar) {
 			switch msg.String() {
 			case "g":
@@ -102,22 +118,22 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return mediaSongPrev(m, msg)
 
 		case "N":
-			m = mediaAddSongNext(m)
+			m, cmd = mediaAddSongNext(m)
 
 		case "a":
-			m = mediaAddSongToQueue(m)
+			m, cmd = mediaAddSongToQueue(m)
 
 		case "d":
-			m = mediaDeleteSongFromQueue(m)
+			m, cmd = mediaDeleteSongFromQueue(m)
 
 		case "D":
-			m = mediaDeleteQueue(m)
+			m, cmd = mediaDeleteQueue(m)
 
 		case "K":
-			m = mediaSongUpQueue(m)
+			m, cmd = mediaSongUpQueue(m)
 
 		case "J":
-			m = mediaSongDownQueue(m)
+			m, cmd = mediaSongDownQueue(m)
 
 		case "w":
 			m = mediaRestartSong(m)
@@ -128,8 +144,26 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case ";":
 			m = mediaSeekForward(m)
 
+		case "<":
+			return mediaSeek(m, msg, -1)
+
+		case ">":
+			return mediaSeek(m, msg, 1)
+
+		case ":":
+			return mediaSeekPrompt(m, msg)
+
+		case "0", "1", "2", "3", "4", "5", "6", "7", "8", "9":
+			return mediaSeekPercent(m, msg)
+
+		case "(":
+			return mediaSeekChapter(m, msg, -1)
+
+		case ")":
+			return mediaSeekChapter(m, msg, 1)
+
 		case "S":
-			m = mediaShuffle(m)
+			m, cmd = mediaShuffle(m)
 
 		case "L":
 			m = mediaToggleLoop(m)
@@ -139,16 +173,138 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		case "F":
 			return mediaShowFavorites(m, msg)
+
+		case "A":
+			return openArtistIndex(m, msg)
+
+		case "'":
+			if m.focus == focusMain && m.displayMode == displayArtist && len(m.artistGroups) > 0 {
+				m.lastKey = "'"
+			}
+
+		case "ctrl+x":
+			return m, purgeCacheCmd()
+
+		case "ctrl+p":
+			return toggleProfiles(m, msg)
+
+		case "T":
+			return mediaCycleTranscoding(m, msg)
+
+		case "R":
+			return mediaCycleReplayGain(m, msg)
+
+		case "o":
+			return downloadSelection(m, msg)
+
+		case "O":
+			return toggleDownloads(m, msg)
+
+		case "+", "=":
+			return mediaChangeVolume(m, msg, 5)
+
+		case "-":
+			return mediaChangeVolume(m, msg, -5)
+
+		case "m":
+			return mediaToggleMute(m, msg)
+
+		case "[":
+			return mediaChangeSpeed(m, msg, -1)
+
+		case "]":
+			return mediaChangeSpeed(m, msg, 1)
+
+		case "\\":
+			return mediaChangeSpeed(m, msg, 0)
+
+		case "X":
+			return mediaFadePrompt(m, msg)
+
+		case "E":
+			return toggleEqualizer(m, msg)
+
+		case "ctrl+o":
+			return toggleAudioDevices(m, msg)
+
+		case "H":
+			return toggleStats(m, msg)
+
+		case "z":
+			return mediaSleepPrompt(m, msg)
+
+		case "Z":
+			return mediaToggleStopAfterCurrent(m, msg)
+
+		case "left", "h":
+			if m.viewMode == viewEqualizer && m.focus == focusMain {
+				m = adjustEqualizerBand(m, -1)
+			}
+			if m.viewMode == viewStats && m.focus == focusMain {
+				return changeStatsPeriod(m, -1)
+			}
+
+		case "right", "l":
+			if m.viewMode == viewEqualizer && m.focus == focusMain {
+				m = adjustEqualizerBand(m, 1)
+			}
+			if m.viewMode == viewStats && m.focus == focusMain {
+				return changeStatsPeriod(m, 1)
+			}
+
+		case "s":
+			if m.viewMode == viewEqualizer && m.focus == focusMain {
+				return openPrompt(m, promptEqualizerPreset, "Preset name: ", ""), textinput.Blink
+			}
 		}
 
 	case playlistResultMsg:
 		m.playlists = msg.playlists
 
+	case audioDevicesMsg:
+		m.audioDevices = msg.devices
+
+	case statsResultMsg:
+		m.stats = msg.stats
+
+	case passwordCommandMsg:
+		return passwordCommandDone(m, msg)
+
+	case remoteCommandMsg:
+		return runRemoteCommand(m, msg)
+
+	case remoteSongsMsg:
+		return addRemoteSongs(m, msg)
+
 	case errMsg:
 		m.loading = false
 		m.err = msg.err
 
-	case statusMsg:
+	case playerEventMsg:
+		var cmds []tea.Cmd
+		status := msg.status
+		m = m.trackPlay(msg.event, status)
+
+		switch {
+		case msg.event.Type == player.EventTrackEnded && msg.event.Reason == player.EndError:
+			m.err = fmt.Errorf("playback failed: %s", msg.event.Err)
+		case msg.event.Type == player.EventPlayerRestarted:
+			m.err = fmt.Errorf("mpv stopped unexpectedly and was restarted")
+		case msg.event.Type == player.EventPlayerFailed:
+			m.err = fmt.Errorf("mpv stopped and could not be restarted: %s", msg.event.Err)
+		}
+
+		// Nothing was prefetched, so a stop was reached
+		if msg.event.Type == player.EventTrackEnded && msg.event.Reason == player.EndEOF && status.SongID == "" {
+			m = m.stopped()
+		}
+
+		// mpv moved on to the prefetched song
+		if status.Track != m.playerStatus.Track && status.SongID != "" && status.SongID == m.playerStatus.NextID {
+			m.queueIndex = m.advancedIndex(status.SongID)
+			m.lastPlayedSongID = ""
+		}
+
 		if len(m.queue) > 0 {
 			currentSong := m.queue[m.queueIndex]
 
@@ -156,19 +312,15 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.lastPlayedSongID = currentSong.ID
 
 				m.scrobbled = false
+				m.playStarted = time.Now()
+				m = m.trackStarted()
 
-				go func() {
-					artBytes, err := api.SubsonicCoverArt(currentSong.ID)
-
-					title := "SubTUI"
-					description := fmt.Sprintf("Playing %s - %s", currentSong.Title, currentSong.Artist)
+				if speed := api.Speed(contentKind(currentSong)); speed != status.Speed {
+					m.player.SetSpeed(speed)
+				}
+				m = m.applyEqualizer()
 
-					if err != nil {
-						_ = beeep.Notify(title, description, "")
-					} else {
-						_ = beeep.Notify(title, description, artBytes)
-					}
-				}()
+				cmds = append(cmds, notifyPlayingCmd(currentSong))
 			}
 		}
 
@@ -184,51 +336,68 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if pos >= target {
 					m.scrobbled = true
 
-					go api.SubsonicScrobble(currentSong.ID, true)
+					cmds = append(cmds, submitScrobbleCmd(currentSong.ID, m.playStarted))
 				}
 			}
 		}
 
-		m.playerStatus = player.PlayerStatus(msg)
+		m.playerStatus = status
 
-		if ((m.playerStatus.Duration > 0 && m.playerStatus.Current >= m.playerStatus.Duration-0.5) ||
-			(m.playerStatus.Title == "<nil>" && m.queueIndex != len(m.queue)-1)) &&
-			!m.playerStatus.Paused {
+		windowTitle := "SubTUI"
+		if m.playerStatus.Title != "" {
+			windowTitle = fmt.Sprintf("%s - %s", m.playerStatus.Title, m.playerStatus.Artist)
+		}
 
-			switch m.loopMode {
-			case LoopNone:
-				//
-			case LoopAll:
-				if m.queueIndex == len(m.queue)-1 {
-					m.queueIndex = -1
-				}
+		cmds = append(cmds, waitForPlayerEvent(m.player), tea.SetWindowTitle(windowTitle), m.prefetchNext())
+		return m, tea.Batch(cmds...)
 
-			case LoopOne:
-				m.queueIndex = m.queueIndex - 1
+	case librarySyncedMsg:
+		if msg.err != nil {
+			m.err = msg.err
+		}
+		return m, scheduleLibrarySyncCmd()
 
-			}
+	case librarySyncTickMsg:
+		return m, tea.Batch(syncLibraryCmd(), m.startRefresh())
 
-			return m, tea.Batch(
-				m.playNext(),
-				syncPlayerCmd(),
-			)
+	case saveVolumeMsg:
+		return saveVolume(m, msg)
+
+	case scrobbleRetryTickMsg:
+		return m, tea.Batch(flushScrobblesCmd(), scrobbleRetryTickCmd())
+
+	case sleepTickMsg:
+		return updateSleep(m)
+
+	case refreshTickMsg:
+		if m.viewMode == viewDownloads || library.Syncing() || download.Active() {
+			return m, refreshTickCmd()
 		}
+		m.refreshing = false
 
-		windowTitle := "SubTUI"
-		if m.playerStatus.Title != "" && m.playerStatus.Title != "<nil>" {
-			windowTitle = fmt.Sprintf("%s - %s", m.playerStatus.Title, m.playerStatus.Artist)
+	case cacheUpdatedMsg:
+		if m.reload == nil {
+			return m, waitForCacheUpdate()
 		}
+		return m, tea.Batch(waitForCacheUpdate(), refreshViewCmd(m.reload, m.viewGen))
 
-		return m, tea.Batch(syncPlayerCmd(), tea.SetWindowTitle(windowTitle))
+	case viewRefreshedMsg:
+		return refreshView(m, msg)
 
 	case songsResultMsg:
+		m.reload = msg.reload
+		m.viewGen++
 		m.loading = false
 		m.songs = msg.songs
+		m.songsView = msg.view
+		m.localMatches = nil
 		m.cursorMain = 0
 		m.mainOffset = 0
 		m.focus = focusMain
 
 	case albumsResultMsg:
+		m.reload = msg.reload
+		m.viewGen++
 		m.loading = false
 		m.albums = msg.albums
 		m.cursorMain = 0
@@ -236,8 +405,31 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.focus = focusMain
 
 	case artistsResultMsg:
+		m.reload = nil
+		m.viewGen++
 		m.loading = false
 		m.artists = msg.artists
+		m.artistGroups = nil
+		m.cursorMain = 0
+		m.mainOffset = 0
+		m.focus = focusMain
+
+	case artistIndexResultMsg:
+		m.reload = msg.reload
+		m.viewGen++
+		m.loading = false
+		m.artists = nil
+		m.artistGroups = nil
+		for _, group := range msg.index {
+			for _, artist := range group.Artists {
+				m.artists = append(m.artists, artist)
+				m.artistGroups = append(m.artistGroups, group.Name)
+
+				if artist.Starred != "" {
+					m.starredMap[artist.ID] = true
+				}
+			}
+		}
 		m.cursorMain = 0
 		m.mainOffset = 0
 		m.focus = focusMain
@@ -262,6 +454,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		m.songs = msg.Songs
+		m.songsView = "search"
 
 		return m, nil
 
@@ -279,21 +472,87 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 
 	// Update inputs
-	if m.focus == focusSearch {
+	if m.promptMode != promptNone {
+		m.prompt, cmd = m.prompt.Update(msg)
+	} else if m.focus == focusSearch {
 		m, cmd = typeInput(m, msg)
 	}
 
 	return m, cmd
 }
 
+// refreshView shows a list that was loaded again after a background refresh,
+// keeping the cursor where the user left it.
+func refreshView(m model, msg viewRefreshedMsg) (model, tea.Cmd) {
+	// The user moved on, or the reload failed
+	if msg.gen != m.viewGen || m.loading {
+		return m, nil
+	}
+	switch msg.msg.(type) {
+	case songsResultMsg, albumsResultMsg, artistIndexResultMsg:
+	default:
+		return m, nil
+	}
+
+	cursor, offset, focus := m.cursorMain, m.mainOffset, m.focus
+
+	updated, cmd := m.Update(msg.msg)
+	m = updated.(model)
+
+	length := len(m.songs)
+	switch msg.msg.(type) {
+	case albumsResultMsg:
+		length = len(m.albums)
+	case artistIndexResultMsg:
+		length = len(m.artists)
+	}
+
+	m.cursorMain = max(min(cursor, length-1), 0)
+	m.mainOffset = min(offset, m.cursorMain)
+	m.focus = focus
+
+	return m, cmd
+}
+
 func typeInput(m model, msg tea.Msg) (model, tea.Cmd) {
 	var cmd tea.Cmd
+	query := m.textInput.Value()
 	m.textInput, cmd = m.textInput.Update(msg)
+
+	if m.filterMode == filterLocal && m.textInput.Value() != query {
+		m = localSearch(m)
+	}
+
 	return m, cmd
 }
 
+// Searches the local library index as you type
+func localSearch(m model) model {
+	results := library.Search(m.textInput.Value(), 200)
+
+	m.songs = make([]api.Song, len(results))
+	m.localMatches = make(map[string]map[int][]int, len(results))
+	for i, result := range results {
+		m.songs[i] = result.Song
+		m.localMatches[result.Song.ID] = result.Matches
+	}
+
+	m.loading = false
+	m.viewMode = viewList
+	m.displayMode = displaySongs
+	m.songsView = "search"
+	m.cursorMain = 0
+	m.mainOffset = 0
+
+	return m
+}
+
 func quit(m model, msg tea.Msg) (tea.Model, tea.Cmd) {
 	if m.focus != focusSearch {
+		m = m.finishPlay()
+		if m.volumeUnsaved {
+			_ = api.SaveConfig()
+		}
 		return m, tea.Quit
 	} else {
 		return typeInput(m, msg)
@@ -328,6 +587,12 @@ func enter(m model) (tea.Model, tea.Cmd) {
 	switch m.focus {
 	case focusSearch:
 		query := m.textInput.Value()
+		if m.filterMode == filterLocal {
+			m.focus = focusMain
+			m.textInput.Blur()
+			return m, nil
+		}
+
 		if query != "" {
 			m.loading = true
 			m.focus = focusMain
@@ -346,6 +611,23 @@ func enter(m model) (tea.Model, tea.Cmd) {
 			return m, searchCmd(query, m.filterMode)
 		}
 	case focusMain:
+		if m.viewMode == viewProfiles {
+			return selectProfile(m)
+		}
+
+		if m.viewMode == viewDownloads {
+			download.Retry(m.cursorMain)
+			return m, nil
+		}
+
+		if m.viewMode == viewEqualizer {
+			return nextEqualizerPreset(m), nil
+		}
+
+		if m.viewMode == viewDevices {
+			return selectAudioDevice(m)
+		}
+
 		if m.viewMode == viewList {
 			switch m.displayMode {
 			// Play song
@@ -370,6 +652,7 @@ func enter(m model) (tea.Model, tea.Cmd) {
 				if len(m.artists) > 0 {
 					selectedArtist := m.artists[m.cursorMain]
 					m.loading = true
+					m.displayModePrev = m.displayMode
 					m.displayMode = displayAlbums
 					m.albums = nil
 
@@ -424,6 +707,26 @@ func goBack(m model, msg tea.Msg) (tea.Model, tea.Cmd) {
 		return toggleQueue(m), nil
 	}
 
+	if m.viewMode == viewProfiles {
+		return toggleProfiles(m, msg)
+	}
+
+	if m.viewMode == viewDownloads {
+		return toggleDownloads(m, msg)
+	}
+
+	if m.viewMode == viewEqualizer {
+		return toggleEqualizer(m, msg)
+	}
+
+	if m.viewMode == viewDevices {
+		return toggleAudioDevices(m, msg)
+	}
+
+	if m.viewMode == viewStats {
+		return toggleStats(m, msg)
+	}
+
 	m.displayMode = m.displayModePrev
 	m.displayModePrev = m.displayMode
 
@@ -489,6 +792,14 @@ func navigateDown(m model) model {
 	listLen := 0
 	if m.viewMode == viewQueue {
 		listLen = len(m.queue)
+	} else if m.viewMode == viewProfiles {
+		listLen = len(api.AppConfig.Profiles) + 1
+	} else if m.viewMode == viewDownloads {
+		listLen = len(download.Jobs())
+	} else if m.viewMode == viewEqualizer {
+		listLen = len(api.EqualizerBands)
+	} else if m.viewMode == viewDevices {
+		listLen = len(m.audioDevices)
 	} else if m.displayMode == displaySongs {
 		listLen = len(m.songs)
 	} else if m.displayMode == displayAlbums {
@@ -521,6 +832,11 @@ func displaySongAlbum(m model) (tea.Model, tea.Cmd) {
 	case viewQueue:
 		targetList = m.queue
 	}
+
+	if m.cursorMain >= len(targetList) {
+		return m, nil
+	}
+
 	albumCmd := getAlbumSongs(targetList[m.cursorMain].AlbumID)
 
 	m.viewMode = viewList
@@ -541,6 +857,11 @@ func displaySongArtist(m model) (tea.Model, tea.Cmd) {
 	case viewQueue:
 		targetList = m.queue
 	}
+
+	if m.cursorMain >= len(targetList) {
+		return m, nil
+	}
+
 	albumCmd := getArtistAlbums(targetList[m.cursorMain].ArtistID)
 
 	m.viewMode = viewList
@@ -556,9 +877,14 @@ func displaySongArtist(m model) (tea.Model, tea.Cmd) {
 func cycleFilter(m model, forward bool) model {
 	if m.focus == focusSearch {
 		if forward {
-			m.filterMode = (m.filterMode + 1) % 3
+			m.filterMode = (m.filterMode + 1) % 4
 		} else {
-			m.filterMode = ((m.filterMode-1)%3 + 3) % 3
+			m.filterMode = ((m.filterMode-1)%4 + 4) % 4
+		}
+
+		// Local search is only available with the library index enabled
+		if m.filterMode == filterLocal && !api.AppConfig.LibraryIndex {
+			return cycleFilter(m, forward)
 		}
 
 		switch m.filterMode {
@@ -568,6 +894,8 @@ func cycleFilter(m model, forward bool) model {
 			m.textInput.Placeholder = "Search albums..."
 		case filterArtist:
 			m.textInput.Placeholder = "Search artists..."
+		case filterLocal:
+			m.textInput.Placeholder = "Search library..."
 		}
 	}
 
@@ -601,7 +929,7 @@ func toggleQueue(m model) model {
 
 func mediaTogglePlay(m model) model {
 	if m.focus != focusSearch {
-		player.TogglePause()
+		m.player.TogglePause()
 	}
 
 	return m
@@ -625,8 +953,8 @@ func mediaSongPrev(m model, msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 }
 
-func mediaAddSongNext(m model) model {
-	if m.focus == focusMain {
+func mediaAddSongNext(m model) (model, tea.Cmd) {
+	if m.focus == focusMain && m.viewMode == viewList && m.cursorMain < len(m.songs) {
 		selectedSong := m.songs[m.cursorMain]
 
 		if len(m.queue) == 0 {
@@ -637,23 +965,31 @@ func mediaAddSongNext(m model) model {
 			tail := append([]api.Song{}, m.queue[insertAt:]...)
 			m.queue = append(m.queue[:insertAt], append([]api.Song{selectedSong}, tail...)...)
 		}
+
+		return m, m.prefetchNext()
 	}
 
-	return m
+	return m, nil
 }
 
-func mediaAddSongToQueue(m model) model {
-	if m.focus == focusMain {
+func mediaAddSongToQueue(m model) (model, tea.Cmd) {
+	if m.focus == focusMain && m.viewMode == viewList && m.cursorMain < len(m.songs) {
 		m.queue = append(m.queue, m.songs[m.cursorMain])
+		return m, m.prefetchNext()
 	}
 
-	return m
+	return m, nil
 }
 
-func mediaDeleteSongFromQueue(m model) model {
+func mediaDeleteSongFromQueue(m model) (model, tea.Cmd) {
+	var cmd tea.Cmd
 	if m.focus == focusMain && m.viewMode == viewQueue && len(m.queue) > 0 {
 		if m.cursorMain != m.queueIndex {
 			m.queue = append(m.queue[:m.cursorMain], m.queue[m.cursorMain+1:]...)
+			if m.cursorMain < m.queueIndex {
+				m.queueIndex--
+			}
+			cmd = m.prefetchNext()
 		}
 	}
 
@@ -661,46 +997,66 @@ func mediaDeleteSongFromQueue(m model) model {
 		m.cursorMain--
 	}
 
-	return m
+	return m, cmd
 }
 
-func mediaDeleteQueue(m model) model {
+func mediaDeleteQueue(m model) (model, tea.Cmd) {
 	if m.focus == focusMain {
 		m.queue = nil
+		m.queueIndex = 0
+		return m, m.prefetchNext()
 	}
 
-	return m
+	return m, nil
 }
 
-func mediaSongUpQueue(m model) model {
+func mediaSongUpQueue(m model) (model, tea.Cmd) {
 	if m.focus == focusMain && m.viewMode == viewQueue && m.cursorMain > 0 {
 		tempSong := m.queue[m.cursorMain]
 
 		m.queue[m.cursorMain] = m.queue[m.cursorMain-1]
 		m.queue[m.cursorMain-1] = tempSong
 
+		// The playing song keeps its place as the current one
+		switch m.queueIndex {
+		case m.cursorMain:
+			m.queueIndex--
+		case m.cursorMain - 1:
+			m.queueIndex++
+		}
+
 		m.cursorMain--
+		return m, m.prefetchNext()
 	}
 
-	return m
+	return m, nil
 }
 
-func mediaSongDownQueue(m model) model {
+func mediaSongDownQueue(m model) (model, tea.Cmd) {
 	if m.focus == focusMain && m.viewMode == viewQueue && m.cursorMain < len(m.queue)-1 {
 		tempSong := m.queue[m.cursorMain]
 
 		m.queue[m.cursorMain] = m.queue[m.cursorMain+1]
 		m.queue[m.cursorMain+1] = tempSong
 
+		// The playing song keeps its place as the current one
+		switch m.queueIndex {
+		case m.cursorMain:
+			m.queueIndex++
+		case m.cursorMain + 1:
+			m.queueIndex--
+		}
+
 		m.cursorMain++
+		return m, m.prefetchNext()
 	}
 
-	return m
+	return m, nil
 }
 
 func mediaRestartSong(m model) model {
 	if m.focus != focusSearch {
-		player.RestartSong()
+		m.player.SeekTo(0)
 	}
 
 	return m
@@ -708,7 +1064,8 @@ func mediaRestartSong(m model) model {
 
 func mediaSeekForward(m model) model {
 	if m.focus != focusSearch {
-		player.Forward10Seconds()
+		small, _ := api.SeekSteps()
+		m.player.Seek(small)
 	}
 
 	return m
@@ -716,16 +1073,61 @@ func mediaSeekForward(m model) model {
 
 func mediaSeekRewind(m model) model {
 	if m.focus != focusSearch {
-		player.Back10Seconds()
+		small, _ := api.SeekSteps()
+		m.player.Seek(-small)
 	}
 
 	return m
 }
 
-func mediaShuffle(m model) model {
+// mediaSeek jumps the large step in direction
+func mediaSeek(m model, msg tea.Msg, direction float64) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	_, large := api.SeekSteps()
+	m.player.Seek(direction * large)
+
+	return m, nil
+}
+
+func mediaSeekPrompt(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	return openPrompt(m, promptSeek, "Go to: ", "1:23 or 45%"), textinput.Blink
+}
+
+// Keys 0-9 jump to 0-90% of the song
+func mediaSeekPercent(m model, msg tea.KeyMsg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	digit := float64(msg.String()[0] - '0')
+	m.player.SeekTo(m.playerStatus.Duration * digit / 10)
+
+	return m, nil
+}
+
+func mediaSeekChapter(m model, msg tea.Msg, delta int) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	if m.playerStatus.Chapters > 0 {
+		m.player.SeekChapter(delta)
+	}
+
+	return m, nil
+}
+
+func mediaShuffle(m model) (model, tea.Cmd) {
 	if m.focus != focusSearch {
 		if len(m.queue) < 2 {
-			return m
+			return m, nil
 		}
 
 		newQueue := make([]api.Song, len(m.queue))
@@ -754,9 +1156,11 @@ func mediaShuffle(m model) model {
 		} else {
 			m.queueIndex = 0
 		}
+
+		return m, m.prefetchNext()
 	}
 
-	return m
+	return m, nil
 }
 
 func mediaToggleLoop(m model) model {
@@ -767,6 +1171,123 @@ func mediaToggleLoop(m model) model {
 	return m
 }
 
+// The new profile applies from the next loaded track
+func mediaCycleTranscoding(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	api.CycleTranscodeProfile()
+	if err := api.SaveConfig(); err != nil {
+		m.err = err
+	}
+
+	return m, nil
+}
+
+func mediaChangeVolume(m model, msg tea.Msg, delta float64) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	volume := math.Round(m.playerStatus.Volume + delta)
+	volume = math.Max(0, math.Min(volume, float64(api.MaxVolume())))
+
+	m.player.SetVolume(volume)
+	m.playerStatus.Volume = volume
+
+	saved := int(volume)
+	api.AppConfig.Player.Volume = &saved
+
+	// Saved once the key is let go rather than on every repeat
+	m.volumeChanges++
+	m.volumeUnsaved = true
+	changes := m.volumeChanges
+	return m, tick(time.Second, func(time.Time) tea.Msg {
+		return saveVolumeMsg{changes}
+	})
+}
+
+func saveVolume(m model, msg saveVolumeMsg) (model, tea.Cmd) {
+	if msg.changes != m.volumeChanges || !m.volumeUnsaved {
+		return m, nil
+	}
+
+	m.volumeUnsaved = false
+	if err := api.SaveConfig(); err != nil {
+		m.err = err
+	}
+
+	return m, nil
+}
+
+func mediaToggleMute(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	m.playerStatus.Muted = !m.playerStatus.Muted
+	m.player.SetMute(m.playerStatus.Muted)
+
+	return m, nil
+}
+
+// mediaChangeSpeed steps through api.Speeds, or resets to 1x for step 0.
+// The speed is remembered for the kind of content that is playing.
+func mediaChangeSpeed(m model, msg tea.Msg, step int) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	speed := 1.0
+	if step != 0 {
+		index := 0
+		for i, s := range api.Speeds {
+			if s <= m.playerStatus.Speed {
+				index = i
+			}
+		}
+		index = max(0, min(index+step, len(api.Speeds)-1))
+		speed = api.Speeds[index]
+	}
+
+	m.player.SetSpeed(speed)
+	m.playerStatus.Speed = speed
+
+	kind := "music"
+	if len(m.queue) > 0 && m.queueIndex < len(m.queue) {
+		kind = contentKind(m.queue[m.queueIndex])
+	}
+
+	api.SetSpeed(kind, speed)
+	if err := api.SaveConfig(); err != nil {
+		m.err = err
+	}
+
+	return m, nil
+}
+
+func mediaFadePrompt(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	return openPrompt(m, promptFade, "Seconds to fade out and in between songs: ", "0 for gapless"), textinput.Blink
+}
+
+func mediaCycleReplayGain(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	api.CycleReplayGainMode()
+	if err := api.SaveConfig(); err != nil {
+		m.err = err
+	}
+
+	return m, m.refreshPlaylist()
+}
+
 func mediaToggleFavorite(m model, msg tea.Msg) (model, tea.Cmd) {
 	if m.focus == focusSearch {
 		return typeInput(m, msg)
@@ -775,7 +1296,7 @@ func mediaToggleFavorite(m model, msg tea.Msg) (model, tea.Cmd) {
 	id := ""
 
 	switch m.filterMode {
-	case filterSongs:
+	case filterSongs, filterLocal:
 
 		var targetList []api.Song
 		switch m.viewMode {
@@ -827,6 +1348,215 @@ func mediaShowFavorites(m model, msg tea.Msg) (model, tea.Cmd) {
 	return m, openLikedSongsCmd()
 }
 
+func openArtistIndex(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	m.displayModePrev = m.displayMode
+	m.displayMode = displayArtist
+	m.viewMode = viewList
+	m.focus = focusMain
+	m.loading = true
+
+	return m, getArtistIndex()
+}
+
+// Moves the cursor to the first artist of the index group starting with key
+func jumpToLetter(m model, key string) model {
+	target := strings.ToUpper(key)
+
+	for i, group := range m.artistGroups {
+		if strings.ToUpper(group) == target {
+			m.cursorMain = i
+			m.mainOffset = i
+			break
+		}
+	}
+
+	return m
+}
+
+// Downloads the selected song, album or sidebar playlist
+func downloadSelection(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	if m.focus == focusSidebar {
+		albumOffset := len(albumTypes)
+		if m.cursorSide >= albumOffset && m.cursorSide-albumOffset < len(m.playlists) {
+			return m, tea.Batch(downloadPlaylistCmd(m.playlists[m.cursorSide-albumOffset].ID), m.startRefresh())
+		}
+		return m, nil
+	}
+
+	if m.focus != focusMain {
+		return m, nil
+	}
+
+	switch {
+	case m.viewMode == viewQueue && m.cursorMain < len(m.queue):
+		download.Enqueue([]api.Song{m.queue[m.cursorMain]})
+	case m.viewMode == viewList && m.displayMode == displaySongs && m.cursorMain < len(m.songs):
+		download.Enqueue([]api.Song{m.songs[m.cursorMain]})
+	case m.viewMode == viewList && m.displayMode == displayAlbums && m.cursorMain < len(m.albums):
+		return m, tea.Batch(downloadAlbumCmd(m.albums[m.cursorMain].ID), m.startRefresh())
+	}
+
+	return m, m.startRefresh()
+}
+
+// startRefresh redraws periodically until downloads and library syncs are done
+func (m *model) startRefresh() tea.Cmd {
+	if m.refreshing {
+		return nil
+	}
+
+	m.refreshing = true
+	return refreshTickCmd()
+}
+
+func toggleDownloads(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	if m.viewMode == viewDownloads {
+		m.viewMode = viewList
+		m.displayMode = m.displayModePrev
+	} else {
+		m.viewMode = viewDownloads
+		m.displayModePrev = m.displayMode
+	}
+	m.focus = focusMain
+	m.cursorMain = 0
+	m.mainOffset = 0
+
+	return m, m.startRefresh()
+}
+
+func toggleProfiles(m model, msg tea.Msg) (model, tea.Cmd) {
+	if m.focus == focusSearch {
+		return typeInput(m, msg)
+	}
+
+	if m.viewMode == viewProfiles {
+		m.viewMode = viewList
+		m.displayMode = m.displayModePrev
+	} else {
+		m.viewMode = viewProfiles
+		m.displayModePrev = m.displayMode
+	}
+	m.focus = focusMain
+	m.cursorMain = 0
+	m.mainOffset = 0
+
+	return m, nil
+}
+
+// Selecting the entry after the last profile opens the login screen to add one
+func selectProfile(m model) (model, tea.Cmd) {
+	if m.cursorMain >= len(api.AppConfig.Profiles) {
+		m.viewMode = viewLogin
+		m.loginInputs = initialLoginInputs()
+		m.loginFocus = 0
+		return m, nil
+	}
+
+	name := api.AppConfig.Profiles[m.cursorMain].Name
+
+	if cmd := api.PasswordCommand(name); cmd != nil {
+		out := &strings.Builder{}
+		cmd.Stdout = out
+		return m, tea.ExecProcess(cmd, func(err error) tea.Msg {
+			return passwordCommandMsg{name, out, err}
+		})
+	}
+
+	return switchProfile(m, name)
+}
+
+// passwordCommandDone unlocks a profile with the output of its
+// password_command, which ran with the terminal handed over.
+func passwordCommandDone(m model, msg passwordCommandMsg) (model, tea.Cmd) {
+	if msg.err != nil {
+		m.err = fmt.Errorf("password_command of profile %q: %v", msg.name, msg.err)
+		return m, nil
+	}
+
+	if err := api.UnlockWithOutput(msg.name, msg.out.String()); err != nil {
+		m.err = err
+		return m, nil
+	}
+
+	return switchProfile(m, msg.name)
+}
+
+func switchProfile(m model, name string) (model, tea.Cmd) {
+	// Passwords are unlocked when their profile is first used
+	if err := api.UnlockProfile(name); err != nil {
+		m.err = err
+		return m, nil
+	}
+
+	// The play so far goes to the history of the server it was played from
+	m = m.finishPlay()
+
+	if err := api.SelectProfile(name); err != nil {
+		m.err = err
+		return m, nil
+	}
+
+	if err := api.SaveConfig(); err != nil {
+		m.err = err
+	}
+
+	return m, m.resetSession()
+}
+
+// Drops everything tied to the previous server and loads the current one
+func (m *model) resetSession() tea.Cmd {
+	m.player.Stop()
+	library.Reset()
+	download.Reload()
+
+	m.queue = nil
+	m.queueIndex = 0
+	m.songs = nil
+	m.albums = nil
+	m.artists = nil
+	m.artistGroups = nil
+	m.playlists = nil
+	m.localMatches = nil
+	m.starredMap = make(map[string]bool)
+	m.lastPlayedSongID = ""
+	m.scrobbled = false
+	m.err = nil
+
+	m.loading = false
+	m.viewMode = viewList
+	m.displayMode = displaySongs
+	m.focus = focusMain
+	m.cursorMain = 0
+	m.cursorSide = 0
+	m.mainOffset = 0
+
+	cmds := []tea.Cmd{
+		checkLoginCmd(),
+		getPlaylists(),
+		getPlayQueue(),
+		getStarredCmd(),
+		flushScrobblesCmd(),
+	}
+
+	if api.AppConfig.LibraryIndex {
+		cmds = append(cmds, syncLibraryCmd(), m.startRefresh())
+	}
+
+	return tea.Batch(cmds...)
+}
+
 func (m *model) updateLoginInputs(msg tea.Msg) tea.Cmd {
 	cmds := make([]tea.Cmd, len(m.loginInputs))
 	for i := range m.loginInputs {
@@ -839,30 +1569,52 @@ func login(m model, msg tea.Msg) (model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch msg.String() {
+		case "esc":
+			// Cancel adding a profile
+			if len(api.AppConfig.Profiles) > 0 {
+				m.viewMode = viewList
+				m.loginInputs = initialLoginInputs()
+				m.loginFocus = 0
+			}
+			return m, nil
+
 		case "tab", "shift+tab", "enter", "up", "down":
 			s := msg.String()
 
 			// Cycle focus logic
 			if s == "enter" && m.loginFocus == len(m.loginInputs)-1 {
-				m.loading = true
+				firstLogin := len(api.AppConfig.Profiles) == 0
 
-				api.AppConfig.URL = m.loginInputs[0].Value()
-				api.AppConfig.Username = m.loginInputs[1].Value()
-				api.AppConfig.Password = m.loginInputs[2].Value()
+				if len(m.loginInputs) > 4 {
+					if err := api.SetPassphrase(m.loginInputs[4].Value()); err != nil {
+						m.err = err
+						return m, nil
+					}
+				}
+
+				err := api.AddProfile(api.Profile{
+					Name:     strings.TrimSpace(m.loginInputs[3].Value()),
+					URL:      m.loginInputs[0].Value(),
+					Username: m.loginInputs[1].Value(),
+					Password: m.loginInputs[2].Value(),
+				})
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
 
 				if err := api.SaveConfig(); err != nil {
 					m.err = err
 					return m, nil
 				}
 
-				_ = player.InitPlayer()
-				m.viewMode = viewList
-				m.focus = focusMain
+				m.loginInputs = initialLoginInputs()
+				m.loginFocus = 0
 
-				return m, tea.Batch(
-					checkLoginCmd(),
-					getPlaylists(),
-				)
+				if firstLogin {
+					return m, tea.Sequence(startPlayerCmd(m.player), m.resetSession())
+				}
+				return m, m.resetSession()
 			}
 
 			if s == "up" || s == "shift+tab" {
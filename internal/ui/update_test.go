@@ -0,0 +1,280 @@
+package ui
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MattiaPun/SubTUI/internal/api"
+	"github.com/MattiaPun/SubTUI/internal/player"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gen2brain/beeep"
+)
+
+// harness runs the model like Bubble Tea does, with the fake player in place
+// of mpv, but synchronously: commands run one after another and the fake's
+// events are delivered by the harness itself, so no test waits on a clock.
+type harness struct {
+	t    *testing.T
+	m    model
+	fake *player.Fake
+	cmds []tea.Cmd
+}
+
+// syncPlayer hands the model a closed event channel, so its waits for
+// player events return right away and the harness delivers them instead.
+type syncPlayer struct {
+	*player.Fake
+}
+
+var closedEvents = func() chan player.Event {
+	events := make(chan player.Event)
+	close(events)
+	return events
+}()
+
+func (syncPlayer) Events() <-chan player.Event {
+	return closedEvents
+}
+
+func newHarness(t *testing.T, songs ...string) *harness {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	// Timers would only fire after the test
+	tick = func(time.Duration, func(time.Time) tea.Msg) tea.Cmd { return nil }
+	t.Cleanup(func() { tick = tea.Tick })
+	notify = func(string, string, any) error { return nil }
+	t.Cleanup(func() { notify = beeep.Notify })
+
+	dir := t.TempDir()
+	for _, env := range []string{"XDG_CONFIG_HOME", "XDG_CACHE_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_RUNTIME_DIR"} {
+		t.Setenv(env, filepath.Join(dir, env))
+	}
+
+	// Nothing listens there, so server requests fail right away
+	api.ConfigPath = filepath.Join(dir, "config.yaml")
+	api.AppConfig = api.Config{
+		Profiles:      []api.Profile{{Name: "test", URL: "http://127.0.0.1:1", Username: "user", Password: "secret"}},
+		ActiveProfile: "test",
+	}
+	api.AppConfig.Secrets.Backend = api.SecretsPlain
+
+	h := &harness{t: t, fake: player.NewFake(nil)}
+	h.m = InitialModel(syncPlayer{h.fake})
+	h.m.focus = focusMain
+	for _, id := range songs {
+		h.m.songs = append(h.m.songs, api.Song{ID: id, Title: id})
+	}
+
+	return h
+}
+
+func (h *harness) send(msg tea.Msg) {
+	m, cmd := h.m.Update(msg)
+	h.m = m.(model)
+	if cmd != nil {
+		h.cmds = append(h.cmds, cmd)
+	}
+}
+
+func (h *harness) key(key string) {
+	msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+	if key == "enter" {
+		msg = tea.KeyMsg{Type: tea.KeyEnter}
+	}
+
+	h.send(msg)
+	h.settle()
+}
+
+// settle runs commands and delivers player events until there are none left.
+func (h *harness) settle() {
+	for {
+		if len(h.cmds) > 0 {
+			cmd := h.cmds[0]
+			h.cmds = h.cmds[1:]
+			h.deliver(cmd())
+			continue
+		}
+
+		select {
+		case event := <-h.fake.Events():
+			h.send(playerEventMsg{event, h.fake.Status()})
+		default:
+			return
+		}
+	}
+}
+
+func (h *harness) deliver(msg tea.Msg) {
+	switch msg := msg.(type) {
+	case nil:
+		return
+	case tea.BatchMsg:
+		for _, cmd := range msg {
+			if cmd != nil {
+				h.cmds = append(h.cmds, cmd)
+			}
+		}
+		return
+	}
+
+	// tea.Sequence runs its commands one after another
+	if v := reflect.ValueOf(msg); v.Kind() == reflect.Slice && v.Type().Elem() == reflect.TypeOf(tea.Cmd(nil)) {
+		for i := 0; i < v.Len(); i++ {
+			if cmd := v.Index(i).Interface().(tea.Cmd); cmd != nil {
+				h.deliver(cmd())
+			}
+		}
+		return
+	}
+
+	h.send(msg)
+}
+
+func (h *harness) advance(d time.Duration) {
+	h.fake.Advance(d)
+	h.settle()
+}
+
+func (h *harness) expectPlaying(songID string, queueIndex int) {
+	h.t.Helper()
+
+	if status := h.fake.Status(); status.SongID != songID {
+		h.t.Fatalf("player is on %q, want %q", status.SongID, songID)
+	}
+	if h.m.queueIndex != queueIndex {
+		h.t.Fatalf("queue index is %d, want %d", h.m.queueIndex, queueIndex)
+	}
+	if h.m.playerStatus.SongID != songID {
+		h.t.Fatalf("UI shows %q, want %q", h.m.playerStatus.SongID, songID)
+	}
+}
+
+func TestPlaySelection(t *testing.T) {
+	h := newHarness(t, "a", "b", "c")
+	h.m.cursorMain = 1
+
+	h.key("enter")
+
+	h.expectPlaying("b", 1)
+	if len(h.m.queue) != 3 {
+		t.Fatalf("queue has %d songs, want 3", len(h.m.queue))
+	}
+	if next := h.fake.Status().NextID; next != "c" {
+		t.Fatalf("prefetched %q, want c", next)
+	}
+}
+
+func TestNext(t *testing.T) {
+	h := newHarness(t, "a", "b", "c")
+	h.key("enter")
+
+	h.key("n")
+	h.expectPlaying("b", 1)
+
+	h.key("n")
+	h.expectPlaying("c", 2)
+
+	// The end of the queue
+	h.key("n")
+	h.expectPlaying("c", 2)
+}
+
+func TestGaplessAdvance(t *testing.T) {
+	h := newHarness(t, "a", "b", "c")
+	h.key("enter")
+
+	h.advance(100 * time.Second)
+	h.expectPlaying("a", 0)
+
+	h.advance(90 * time.Second)
+	h.expectPlaying("b", 1)
+	if next := h.fake.Status().NextID; next != "c" {
+		t.Fatalf("prefetched %q, want c", next)
+	}
+
+	h.advance(180 * time.Second)
+	h.expectPlaying("c", 2)
+
+	// Without looping, playback stops after the last song
+	h.advance(180 * time.Second)
+	if status := h.fake.Status(); status.SongID != "" {
+		t.Fatalf("player is on %q after the last song, want it stopped", status.SongID)
+	}
+}
+
+func TestLoopAll(t *testing.T) {
+	h := newHarness(t, "a", "b")
+	h.key("L")
+	if h.m.loopMode != LoopAll {
+		t.Fatalf("loop mode is %d, want LoopAll", h.m.loopMode)
+	}
+
+	h.key("enter")
+	h.advance(180 * time.Second)
+	h.expectPlaying("b", 1)
+
+	h.advance(180 * time.Second)
+	h.expectPlaying("a", 0)
+}
+
+func TestLoopOne(t *testing.T) {
+	h := newHarness(t, "a", "b")
+	h.key("L")
+	h.key("L")
+	if h.m.loopMode != LoopOne {
+		t.Fatalf("loop mode is %d, want LoopOne", h.m.loopMode)
+	}
+
+	h.key("enter")
+	track := h.fake.Status().Track
+
+	h.advance(180 * time.Second)
+	h.expectPlaying("a", 0)
+	if h.fake.Status().Track == track {
+		t.Fatal("song did not start over")
+	}
+
+	h.advance(180 * time.Second)
+	h.expectPlaying("a", 0)
+}
+
+func TestQueueEditsPrefetch(t *testing.T) {
+	h := newHarness(t, "a", "b", "c")
+	h.key("enter")
+	h.key("Q")
+	if h.m.viewMode != viewQueue {
+		t.Fatalf("view is %d, want the queue", h.m.viewMode)
+	}
+
+	h.key("j")
+	h.key("j")
+	h.key("K")
+	if next := h.fake.Status().NextID; next != "c" {
+		t.Fatalf("prefetched %q after moving c up, want c", next)
+	}
+
+	h.key("d")
+	if next := h.fake.Status().NextID; next != "b" {
+		t.Fatalf("prefetched %q after deleting c, want b", next)
+	}
+
+	// Moving the playing song keeps it current
+	h.key("k")
+	h.key("J")
+	h.expectPlaying("a", 1)
+	if next := h.fake.Status().NextID; next != "" {
+		t.Fatalf("prefetched %q at the end of the queue, want nothing", next)
+	}
+
+	h.key("D")
+	if len(h.m.queue) != 0 {
+		t.Fatalf("queue has %d songs after clearing, want 0", len(h.m.queue))
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"strings"
 
 	"github.com/MattiaPun/SubTUI/internal/api"
+	"github.com/MattiaPun/SubTUI/internal/download"
+	"github.com/MattiaPun/SubTUI/internal/library"
+	"github.com/MattiaPun/SubTUI/internal/scrobble"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/mattn/go-runewidth"
 )
@@ -59,7 +62,17 @@ func (m model) View() string {
 	}
 
 	mainContent := ""
-	if m.loading {
+	if m.viewMode == viewProfiles {
+		mainContent = mainProfilesContent(m, mainWidth)
+	} else if m.viewMode == viewDownloads {
+		mainContent = mainDownloadsContent(m, mainWidth, mainHeight)
+	} else if m.viewMode == viewEqualizer {
+		mainContent = mainEqualizerContent(m, mainWidth)
+	} else if m.viewMode == viewDevices {
+		mainContent = mainDevicesContent(m, mainWidth)
+	} else if m.viewMode == viewStats {
+		mainContent = mainStatsContent(m, mainWidth)
+	} else if m.loading {
 		mainContent = "\n  Searching your library..."
 	} else if m.displayMode == displaySongs {
 		mainContent = mainSongsContent(m, mainWidth, mainHeight)
@@ -135,16 +148,50 @@ func LimitString(s string, limit int) string {
 	return res + strings.Repeat(" ", limit-curWidth)
 }
 
+// Pads s like LimitString and highlights the runes at the given positions
+func highlightMatches(s string, positions []int, limit int, base lipgloss.Style) string {
+	text := LimitString(s, limit)
+	if len(positions) == 0 {
+		return base.Render(text)
+	}
+
+	matched := make(map[int]bool, len(positions))
+	for _, p := range positions {
+		matched[p] = true
+	}
+
+	matchStyle := base.Foreground(special).Underline(true)
+
+	res := ""
+	for i, r := range []rune(text) {
+		if matched[i] {
+			res += matchStyle.Render(string(r))
+		} else {
+			res += base.Render(string(r))
+		}
+	}
+
+	return res
+}
+
 func loginView(m model) string {
-	content := lipgloss.JoinVertical(lipgloss.Center,
-		loginHeaderStyle.Render("Welcome to SubTUI"),
-		"", // Spacer
-		m.loginInputs[0].View(),
-		m.loginInputs[1].View(),
-		m.loginInputs[2].View(),
-		"", // Spacer
-		loginHelpStyle.Render("[ Press Enter to Login ]"),
-	)
+	help := "[ Press Enter to Login ]"
+	if len(api.AppConfig.Profiles) > 0 {
+		help = "[ Press Enter to add Profile, Esc to cancel ]"
+	}
+
+	rows := []string{loginHeaderStyle.Render("Welcome to SubTUI"), ""}
+	for _, input := range m.loginInputs {
+		rows = append(rows, input.View())
+	}
+	rows = append(rows, "", loginHelpStyle.Render(help))
+
+	content := lipgloss.JoinVertical(lipgloss.Center, rows...)
+
+	if m.err != nil {
+		content = lipgloss.JoinVertical(lipgloss.Center, content, "",
+			lipgloss.NewStyle().Foreground(errorColor).Render(truncate(m.err.Error(), 60)))
+	}
 
 	box := loginBoxStyle.Render(content)
 
@@ -162,6 +209,9 @@ func loginView(m model) string {
 func headerContent(m model) string {
 
 	leftContent := "Search: " + m.textInput.View()
+	if m.promptMode != promptNone {
+		leftContent = m.prompt.View()
+	}
 	rightContent := ""
 
 	switch m.filterMode {
@@ -171,6 +221,21 @@ func headerContent(m model) string {
 		rightContent = "< Albums >"
 	case filterArtist:
 		rightContent = "< Artist >"
+	case filterLocal:
+		if library.Syncing() {
+			rightContent = "< Library (syncing) >"
+		} else {
+			rightContent = fmt.Sprintf("< Library (%d) >", library.SongCount())
+		}
+	}
+
+	if api.InsecureTLS() {
+		rightContent = lipgloss.NewStyle().Foreground(errorColor).Bold(true).Render("TLS NOT VERIFIED") + "  " + rightContent
+	}
+
+	if m.err != nil {
+		maxWidth := m.width - 5 - lipgloss.Width(leftContent) - lipgloss.Width(rightContent) - 2
+		rightContent = lipgloss.NewStyle().Foreground(errorColor).Render(truncate(m.err.Error(), maxWidth)) + "  " + rightContent
 	}
 
 	innerWidth := m.width - 5
@@ -231,7 +296,6 @@ func mainSongsContent(m model, mainWidth int, mainHeight int) string {
 	var targetList []api.Song
 
 	if m.viewMode == viewList {
-		mainTableHeader = "TITLE"
 		targetList = m.songs
 		mainContent = "\n  Use the search bar to find Songs."
 	} else {
@@ -244,19 +308,25 @@ func mainSongsContent(m model, mainWidth int, mainHeight int) string {
 		return mainContent
 	}
 
-	availableWidth := mainWidth - 4
-	colTitle := int(float64(availableWidth) * 0.40)
-	colArtist := int(float64(availableWidth) * 0.15)
-	colAlbum := int(float64(availableWidth) * 0.25)
-	// Time takes whatever is left
+	view := m.songsView
+	if m.viewMode == viewQueue {
+		view = "queue"
+	}
+	columns, widths := layoutColumns(api.SongColumns(view), mainWidth-4)
+	if len(columns) == 0 {
+		return "\n  No valid columns configured."
+	}
+
+	headers := make([]string, len(columns))
+	for c, col := range columns {
+		headers[c] = LimitString(col.header, widths[c])
+	}
+	if m.viewMode == viewQueue {
+		headers[0] = LimitString(mainTableHeader, widths[0])
+	}
 
 	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(subtle)
-	header := fmt.Sprintf("  %s %s %s %s",
-		LimitString(mainTableHeader, colTitle),
-		LimitString("ARTIST", colArtist),
-		LimitString("ALBUM", colAlbum),
-		"TIME",
-	)
+	header := "  " + strings.Join(headers, " ")
 
 	mainContent = headerStyle.Render(header) + "\n"
 	mainContent += lipgloss.NewStyle().Foreground(subtle).Render("  "+strings.Repeat("-", mainWidth-4)) + "\n"
@@ -306,15 +376,31 @@ func mainSongsContent(m model, mainWidth int, mainHeight int) string {
 			starIcon = "♥"
 		}
 
-		row := fmt.Sprintf("%s %s %s %s %s",
-			starIcon,
-			LimitString(song.Title, colTitle-2),
-			LimitString(song.Artist, colArtist),
-			LimitString(song.Album, colAlbum),
-			formatDuration(song.Duration),
-		)
+		downloadIcon := " "
+		if download.IsDownloaded(song.ID) {
+			downloadIcon = "↓"
+		}
 
-		mainContent += fmt.Sprintf("%s%s\n", cursor, style.Render(row))
+		// The icons take the first two cells of the first column
+		matches := m.localMatches[song.ID]
+		row := style.Render(starIcon + downloadIcon)
+		for c, col := range columns {
+			width := widths[c]
+			if c == 0 {
+				width -= 2
+			} else {
+				row += style.Render(" ")
+			}
+
+			var positions []int
+			if col.field >= 0 {
+				positions = matches[col.field]
+			}
+
+			row += highlightMatches(col.value(song), positions, width, style)
+		}
+
+		mainContent += fmt.Sprintf("%s%s\n", cursor, row)
 	}
 
 	return mainContent
@@ -390,9 +476,18 @@ func mainArtistContent(m model, mainWidth int, mainHeight int) string {
 		return "\n  Use the search bar to find Artists."
 	}
 
-	colArtist := mainWidth - 4
+	colGroup := 0
+	if len(m.artistGroups) == len(m.artists) {
+		colGroup = 4
+	}
+	colAlbums := 8
+	colArtist := mainWidth - 4 - colGroup - colAlbums - 1
 	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(subtle)
-	header := fmt.Sprintf("  %s", LimitString("ARTIST", colArtist))
+	header := fmt.Sprintf("  %s%s %s",
+		LimitString("", colGroup),
+		LimitString("ARTIST", colArtist),
+		"ALBUMS",
+	)
 
 	mainContent := headerStyle.Render(header) + "\n"
 	mainContent += lipgloss.NewStyle().Foreground(subtle).Render("  "+strings.Repeat("-", mainWidth-4)) + "\n"
@@ -433,9 +528,22 @@ func mainArtistContent(m model, mainWidth int, mainHeight int) string {
 			starIcon = lipgloss.NewStyle().Render("♥︎")
 		}
 
-		row := fmt.Sprintf("%s %s",
+		// Only the first artist of an index group shows the letter
+		group := ""
+		if colGroup > 0 && (i == 0 || m.artistGroups[i] != m.artistGroups[i-1]) {
+			group = m.artistGroups[i]
+		}
+
+		albumCount := ""
+		if artist.AlbumCount > 0 {
+			albumCount = fmt.Sprintf("%d", artist.AlbumCount)
+		}
+
+		row := fmt.Sprintf("%s%s %s %s",
+			LimitString(group, colGroup),
 			starIcon,
 			LimitString(artist.Name, colArtist-2),
+			albumCount,
 		)
 
 		mainContent += fmt.Sprintf("%s%s\n", cursor, style.Render(row))
@@ -444,11 +552,115 @@ func mainArtistContent(m model, mainWidth int, mainHeight int) string {
 	return mainContent
 }
 
+func mainProfilesContent(m model, mainWidth int) string {
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(subtle)
+	mainContent := headerStyle.Render("  PROFILES") + "\n"
+	mainContent += lipgloss.NewStyle().Foreground(subtle).Render("  "+strings.Repeat("-", mainWidth-4)) + "\n"
+
+	active := api.CurrentProfile().Name
+	items := []string{}
+	for _, p := range api.AppConfig.Profiles {
+		marker := " "
+		if p.Name == active {
+			marker = "●"
+		}
+		items = append(items, fmt.Sprintf("%s %s  %s@%s", marker, p.Name, p.Username, p.URL))
+	}
+	items = append(items, "+ Add profile")
+
+	for i, item := range items {
+		cursor := "  "
+		style := lipgloss.NewStyle()
+		if m.cursorMain == i {
+			cursor = "> "
+			style = style.Foreground(highlight).Bold(true)
+		}
+
+		mainContent += cursor + style.Render(LimitString(item, mainWidth-4)) + "\n"
+	}
+
+	return mainContent
+}
+
+func mainDownloadsContent(m model, mainWidth int, mainHeight int) string {
+	count, size := download.Usage()
+	limit := "unlimited"
+	if api.AppConfig.Downloads.MaxSizeMB > 0 {
+		limit = fmt.Sprintf("%d MB", api.AppConfig.Downloads.MaxSizeMB)
+	}
+
+	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(subtle)
+	header := fmt.Sprintf("  DOWNLOADS (%d songs, %d MB of %s)", count, size/1024/1024, limit)
+
+	mainContent := headerStyle.Render(header) + "\n"
+	mainContent += lipgloss.NewStyle().Foreground(subtle).Render("  "+strings.Repeat("-", mainWidth-4)) + "\n"
+
+	jobs := download.Jobs()
+	if len(jobs) == 0 {
+		return mainContent + "\n  Press o on a song, album or playlist to download it."
+	}
+
+	availableWidth := mainWidth - 4
+	colStatus := 14
+	colTitle := int(float64(availableWidth-colStatus) * 0.5)
+	colArtist := availableWidth - colStatus - colTitle - 2
+
+	visibleRows := mainHeight - 4
+	if visibleRows < 1 {
+		visibleRows = 1
+	}
+
+	end := m.mainOffset + visibleRows
+	if end > len(jobs) {
+		end = len(jobs)
+	}
+
+	for i := m.mainOffset; i < end; i++ {
+		job := jobs[i]
+
+		status := ""
+		statusStyle := lipgloss.NewStyle().Foreground(subtle)
+		switch job.Status {
+		case download.StatusQueued:
+			status = "queued"
+		case download.StatusDownloading:
+			status = "downloading"
+			if job.Total > 0 {
+				status = fmt.Sprintf("%d%%", job.Downloaded*100/job.Total)
+			}
+		case download.StatusDone:
+			status = "done"
+			statusStyle = statusStyle.Foreground(special)
+		case download.StatusFailed:
+			status = "failed"
+			statusStyle = statusStyle.Foreground(errorColor)
+		}
+
+		cursor := "  "
+		style := lipgloss.NewStyle()
+		if m.cursorMain == i {
+			cursor = "> "
+			style = style.Foreground(highlight).Bold(true)
+		}
+
+		detail := job.Song.Artist
+		if job.Err != nil {
+			detail = job.Err.Error()
+		}
+
+		mainContent += cursor +
+			statusStyle.Render(LimitString(status, colStatus)) +
+			style.Render(LimitString(job.Song.Title, colTitle)+"  "+LimitString(detail, colArtist)) + "\n"
+	}
+
+	return mainContent
+}
+
 func footerContent(m model) string {
 	title := ""
 	artistAlbumText := ""
 
-	if m.playerStatus.Title == "<nil>" {
+	if m.playerStatus.Title == "" {
 		title = "Nothing playing"
 		artistAlbumText = ""
 	} else if strings.Contains(m.playerStatus.Title, "stream?c=SubTUI") {
@@ -495,17 +707,42 @@ func footerContent(m model) string {
 		loopText = "[Loop one]"
 	}
 
+	volumeText := fmt.Sprintf("[Vol %d%%]", int(m.playerStatus.Volume))
+	if m.playerStatus.Muted {
+		volumeText = "[Muted]"
+	}
+	if speed := m.playerStatus.Speed; speed != 0 && speed != 1 {
+		volumeText += fmt.Sprintf(" [%gx]", speed)
+	}
+	if fade := api.AppConfig.Player.Fade; fade > 0 {
+		volumeText += fmt.Sprintf(" [Fade out/in %gs]", fade)
+	}
+	if pending := scrobble.Pending(); pending > 0 {
+		volumeText += fmt.Sprintf(" [%d unscrobbled]", pending)
+	}
+	if sleep := sleepText(m); sleep != "" {
+		volumeText += " " + sleep
+	}
+	loopText = strings.TrimSpace(volumeText + " " + loopText)
+
 	bottomRowGap := 0
-	bottomRowSpaceTaken := 2 + 3 + 3 + len(artistAlbumText) + len(loopText) // 2: border, 3: spacing, 3: spacing
+	bottomRowSpaceTaken := 2 + 3 + 3 + lipgloss.Width(artistAlbumText) + lipgloss.Width(loopText) // 2: border, 3: spacing, 3: spacing
 	if artistAlbumText != "" && m.width != 0 && m.width-bottomRowSpaceTaken > 0 {
 		bottomRowGap = m.width - bottomRowSpaceTaken
 	} else if m.width != 0 {
-		bottomRowGap = m.width - 2 - 3 - 3 - len(loopText)
+		bottomRowGap = m.width - 2 - 3 - 3 - lipgloss.Width(loopText)
 	}
 
 	bottomRowText := artistAlbumText + strings.Repeat(" ", bottomRowGap) + loopText
 
-	topRow := lipgloss.NewStyle().Bold(true).Foreground(highlight).Render("   " + LimitString(title, m.width-4))
+	streamText := streamInfo(m)
+	titleWidth := m.width - 4 - 3 - lipgloss.Width(streamText)
+	if titleWidth < 0 {
+		titleWidth = 0
+	}
+
+	topRow := lipgloss.NewStyle().Bold(true).Foreground(highlight).Render("   "+LimitString(title, titleWidth)) +
+		lipgloss.NewStyle().Foreground(subtle).Render(streamText)
 	bottomRow := lipgloss.NewStyle().Foreground(subtle).Render("   " + LimitString(bottomRowText, m.width-4))
 
 	rawProgress := fmt.Sprintf("%s %s %s",
@@ -521,3 +758,25 @@ func footerContent(m model) string {
 
 	return fmt.Sprintf("%s\n%s\n\n%s", topRow, bottomRow, rowProgress)
 }
+
+// Effective format and bitrate of the current stream, plus the transcoding profile
+func streamInfo(m model) string {
+	if m.playerStatus.Codec == "" || m.playerStatus.Title == "" {
+		return ""
+	}
+
+	info := m.playerStatus.Codec
+	if m.playerStatus.Bitrate > 0 {
+		info += fmt.Sprintf(" %d kbps", int(m.playerStatus.Bitrate/1000))
+	}
+
+	if name, _ := api.CurrentTranscodeProfile(); name != "" {
+		info += " · " + name
+	}
+
+	if mode := api.AppConfig.ReplayGain.Mode; mode != "" && mode != "off" {
+		info += " · RG " + mode
+	}
+
+	return info
+}
@@ -1,30 +1,162 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 
 	"github.com/MattiaPun/SubTUI/internal/api"
+	"github.com/MattiaPun/SubTUI/internal/download"
+	"github.com/MattiaPun/SubTUI/internal/instance"
 	"github.com/MattiaPun/SubTUI/internal/player"
 	"github.com/MattiaPun/SubTUI/internal/ui"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/term"
 )
 
 func main() {
-	_ = api.LoadConfig()
+	// Flags take precedence over SUBTUI_* variables, which take precedence
+	// over config.yaml
+	debugDefault, _ := strconv.ParseBool(os.Getenv("SUBTUI_DEBUG"))
 
-	if api.AppConfig.Password != "" {
-		if err := player.InitPlayer(); err != nil {
-			fmt.Printf("Failed to start player: %v\n", err)
+	configPath := flag.String("config", os.Getenv("SUBTUI_CONFIG"), "path to config.yaml")
+	profile := flag.String("profile", os.Getenv("SUBTUI_PROFILE"), "server profile to use")
+	server := flag.String("server", os.Getenv("SUBTUI_SERVER"), "server URL, overrides the profile")
+	user := flag.String("user", os.Getenv("SUBTUI_USER"), "username, overrides the profile")
+	debug := flag.Bool("debug", debugDefault, "write a debug log")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [play <query> | queue <query> | toggle]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	command, err := instance.ParseCommand(flag.Args())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	if err := setupLogging(*debug); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	api.ConfigPath = *configPath
+	api.PassphrasePrompt = promptPassphrase
+
+	// Secrets are unlocked further down, once commands were forwarded and
+	// overrides applied, so neither asks for a passphrase it does not need
+	if err := api.LoadConfig(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if command.Action != "" && !api.AppConfig.SingleInstance {
+		fmt.Println("Commands are forwarded to a running SubTUI, which needs single_instance: true in config.yaml")
+		os.Exit(1)
+	}
+
+	if api.AppConfig.SingleInstance {
+		err := instance.Send(command)
+		if err == nil {
+			if command.Action == "" {
+				fmt.Println("SubTUI is already running")
+			}
+			return
+		}
+
+		// Without a running instance, only a plain start goes on
+		if !errors.Is(err, instance.ErrNotRunning) || command.Action != "" {
+			fmt.Println(err)
+			os.Exit(1)
 		}
+	}
+
+	if *profile != "" {
+		if err := api.SelectProfile(*profile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	api.SetOverrides(*server, *user, os.Getenv("SUBTUI_PASSWORD"))
 
+	if err := api.PrepareSecrets(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
-	defer player.ShutdownPlayer()
+	// The TUI owns the terminal from here on
+	api.PassphrasePrompt = nil
+
+	// The UI starts the player, so it can show why that failed
+	mpv := player.NewMPV()
+	defer mpv.Shutdown()
+	defer download.Flush()
+
+	p := tea.NewProgram(ui.InitialModel(mpv), tea.WithAltScreen())
+
+	if api.AppConfig.SingleInstance {
+		listener, err := instance.Listen(func(cmd instance.Command) error {
+			msg, err := ui.RemoteCommand(cmd.Action, cmd.Query)
+			if err != nil {
+				return err
+			}
+			go p.Send(msg)
+			return nil
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer func() { _ = listener.Close() }()
+	}
 
-	p := tea.NewProgram(ui.InitialModel(), tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
 		fmt.Println("Error while running program:", err)
 		os.Exit(1)
 	}
 }
+
+// setupLogging sends the log to a file in debug mode and discards it
+// otherwise, as the terminal belongs to the TUI.
+func setupLogging(debug bool) error {
+	if !debug {
+		log.SetOutput(io.Discard)
+		return nil
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, "subtui", "debug.log")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if _, err := tea.LogToFile(path, ""); err != nil {
+		return fmt.Errorf("could not open debug log: %v", err)
+	}
+
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	return nil
+}
+
+func promptPassphrase() (string, error) {
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		return "", fmt.Errorf("no passphrase for the encrypted password store, set SUBTUI_PASSPHRASE")
+	}
+
+	fmt.Print("SubTUI passphrase: ")
+	passphrase, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Println()
+
+	return string(passphrase), err
+}